@@ -11,6 +11,7 @@ require (
 	github.com/getkin/kin-openapi v0.118.0
 	github.com/joho/godotenv v1.5.1
 	github.com/xeipuuv/gojsonschema v1.2.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -47,7 +48,6 @@ require (
 	github.com/yargevad/filepathx v1.0.0 // indirect
 	golang.org/x/arch v0.11.0 // indirect
 	golang.org/x/net v0.39.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
@@ -21,11 +21,11 @@ import (
 
 func initTest() (*controller.Controller, *browser.Browser, *browser.BrowserContext, playwright.Page) {
 	c := controller.NewController()
-	b := browser.NewBrowser(browser.BrowserConfig{
-		"headless": true,
-	})
+	config := browser.NewBrowserConfig()
+	config.Headless = true
+	b, _ := browser.NewBrowser(config)
 	bc := b.NewContext()
-	page := bc.GetCurrentPage()
+	page, _ := bc.GetCurrentPage()
 	return c, b, bc, page
 }
 
@@ -42,8 +42,8 @@ func tempFunction(_ context.Context, arg1 controller.DoneAction) (*controller.Ac
 func TestNewController(t *testing.T) {
 	c := controller.NewController()
 	t.Log(c)
-	if len(c.Registry.Registry.Actions) != 19 {
-		t.Error("expected 19 actions, got", len(c.Registry.Registry.Actions))
+	if len(c.Registry.Registry.Actions) != 20 {
+		t.Error("expected 20 actions, got", len(c.Registry.Registry.Actions))
 	}
 }
 
@@ -117,10 +117,16 @@ func TestExecuteClickElement(t *testing.T) {
 
 	// ------------------ buildDomTree.js -> set SelectorMap --------------------------
 	// this will be done in Agent.Step() later
-	currentState := bc.GetState(false)
+	currentState, err := bc.GetState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
 	time.Sleep(1 * time.Second)
 
-	session := bc.GetSession()
+	session, err := bc.GetSession()
+	if err != nil {
+		t.Fatal(err)
+	}
 	session.CachedState = currentState
 	// ------------------ buildDomTree.js -> set SelectorMap --------------------------
 
@@ -148,10 +154,16 @@ func TestExecuteInputText(t *testing.T) {
 
 	// ------------------ buildDomTree.js -> set SelectorMap --------------------------
 	// this will be done in Agent.Step() later
-	currentState := bc.GetState(false)
+	currentState, err := bc.GetState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
 	time.Sleep(1 * time.Second)
 
-	session := bc.GetSession()
+	session, err := bc.GetSession()
+	if err != nil {
+		t.Fatal(err)
+	}
 	session.CachedState = currentState
 	// ------------------ buildDomTree.js -> set SelectorMap --------------------------
 
@@ -196,7 +208,11 @@ func TestSearchGoogle(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	url := bc.GetCurrentPage().URL()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := page.URL()
 	// looks like fails in headless mode
 	if !(strings.Contains(url, "https://www.google.com/search") && strings.Contains(url, "Seoul") && strings.Contains(url, "weather")) {
 		t.Error("expected google search page, got", url)
@@ -218,7 +234,11 @@ func TestGoToUrl(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	url := bc.GetCurrentPage().URL()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := page.URL()
 	if !strings.Contains(url, "duckduckgo.com") {
 		t.Error("expected duckduckgo.com, got", url)
 	}
@@ -239,7 +259,11 @@ func TestGoBack(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	url := bc.GetCurrentPage().URL()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := page.URL()
 	if !strings.Contains(url, "duckduckgo.com") {
 		t.Error("expected duckduckgo.com, got", url)
 	}
@@ -272,7 +296,10 @@ func TestSavePdf(t *testing.T) {
 	defer b.Close()
 	defer bc.Close()
 
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
 	page.Goto("https://deepwiki.com/browser-use/browser-use")
 	page.WaitForLoadState(playwright.PageWaitForLoadStateOptions{State: playwright.LoadStateDomcontentloaded})
 	actionResult, err := c.ExecuteAction(&controller.ActModel{
@@ -321,7 +348,10 @@ func TestOpenTab(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	tabsInfo := bc.GetTabsInfo()
+	tabsInfo, err := bc.GetTabsInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(tabsInfo) != 2 {
 		t.Error("expected 2 tabs, got", len(tabsInfo))
 		return
@@ -354,7 +384,10 @@ func TestCloseTab(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	tabsInfo := bc.GetTabsInfo()
+	tabsInfo, err := bc.GetTabsInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(tabsInfo) != 3 {
 		t.Error("expected 3 tabs, got", len(tabsInfo))
 		return
@@ -369,7 +402,10 @@ func TestCloseTab(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	tabsInfo = bc.GetTabsInfo()
+	tabsInfo, err = bc.GetTabsInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(tabsInfo) != 2 {
 		t.Error("expected 2 tabs, got", len(tabsInfo))
 		return
@@ -393,7 +429,10 @@ func TestCloseTab(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	tabsInfo = bc.GetTabsInfo()
+	tabsInfo, err = bc.GetTabsInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(tabsInfo) != 1 {
 		t.Error("expected 1 tab, got", len(tabsInfo))
 		return
@@ -422,7 +461,10 @@ func TestSwitchTab(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	tabsInfo := bc.GetTabsInfo()
+	tabsInfo, err := bc.GetTabsInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(tabsInfo) != 3 {
 		t.Error("expected 3 tabs, got", len(tabsInfo))
 		return
@@ -437,12 +479,18 @@ func TestSwitchTab(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	tabsInfo = bc.GetTabsInfo()
+	tabsInfo, err = bc.GetTabsInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(tabsInfo) != 3 {
 		t.Error("expected 3 tabs, got", len(tabsInfo))
 		return
 	}
-	currentPage := bc.GetCurrentPage()
+	currentPage, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
 	currentPageURL := currentPage.URL()
 	if !strings.Contains(currentPageURL, "duckduckgo.com") {
 		t.Error("expected duckduckgo.com, got", currentPageURL)
@@ -459,12 +507,18 @@ func TestSwitchTab(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	tabsInfo = bc.GetTabsInfo()
+	tabsInfo, err = bc.GetTabsInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(tabsInfo) != 3 {
 		t.Error("expected 3 tabs, got", len(tabsInfo))
 		return
 	}
-	currentPage = bc.GetCurrentPage()
+	currentPage, err = bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
 	currentPageURL = currentPage.URL()
 	if !strings.Contains(currentPageURL, "bing.com") {
 		t.Error("expected bing.com, got", currentPageURL)
@@ -622,7 +676,9 @@ func TestGetDropdownOptions(t *testing.T) {
 	url := "file://" + htmlPath
 
 	bc.NavigateTo(url)
-	bc.GetState(false)
+	if _, err := bc.GetState(false); err != nil {
+		t.Fatal(err)
+	}
 	time.Sleep(1 * time.Second)
 
 	actionResult, err := c.ExecuteAction(&controller.ActModel{
@@ -663,7 +719,9 @@ func TestSelectDropdownOption(t *testing.T) {
 	url := "file://" + htmlPath
 
 	bc.NavigateTo(url)
-	_ = bc.GetState(false)
+	if _, err := bc.GetState(false); err != nil {
+		t.Fatal(err)
+	}
 	time.Sleep(1 * time.Second)
 
 	actionResult, err := c.ExecuteAction(&controller.ActModel{
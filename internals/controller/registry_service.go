@@ -2,11 +2,16 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"regexp"
 	"slices"
+	"strings"
 
+	"github.com/nerdface-ai/browser-use-go/internals/utils"
 	"github.com/nerdface-ai/browser-use-go/pkg/browser"
 
+	"github.com/charmbracelet/log"
 	"github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/components/tool"
 	einoUtils "github.com/cloudwego/eino/components/tool/utils"
@@ -53,13 +58,109 @@ func registerAction[T, D any](
 type contextKey string
 
 const (
-	browserKey            contextKey = "browser"
-	pageExtractionLlmKey  contextKey = "page_extraction_llm"
-	availableFilePathsKey contextKey = "available_file_paths"
+	browserKey             contextKey = "browser"
+	pageExtractionLlmKey   contextKey = "page_extraction_llm"
+	availableFilePathsKey  contextKey = "available_file_paths"
+	hasSensitiveDataKey    contextKey = "has_sensitive_data"
+	domainSensitiveDataKey contextKey = "domain_sensitive_data"
 )
 
+// WithDomainSensitiveData returns a context carrying secrets which are only filled in on pages matching their url pattern,
+// e.g. {"https://*.bank.example": {"bank_password": "..."}}. Pass it to ExecuteActionContext next to the unscoped sensitive data.
+func WithDomainSensitiveData(ctx context.Context, data map[string]map[string]string) context.Context {
+	return context.WithValue(ctx, domainSensitiveDataKey, data)
+}
+
+func getDomainSensitiveData(ctx context.Context) map[string]map[string]string {
+	if data, ok := ctx.Value(domainSensitiveDataKey).(map[string]map[string]string); ok {
+		return data
+	}
+	return nil
+}
+
+var secretPattern = regexp.MustCompile(`<secret>(.*?)</secret>`)
+
+// Collect the secrets which may be used on currentUrl.
+// Domain scoped secrets are only applicable when currentUrl matches their url pattern.
+func (r *Registry) applicableSensitiveData(sensitiveData map[string]string, domainSensitiveData map[string]map[string]string, currentUrl string) map[string]string {
+	applicable := utils.FlattenSensitiveData(sensitiveData, nil)
+	for pattern, secrets := range domainSensitiveData {
+		// never inject scoped secrets into a blank or unknown page
+		if currentUrl == "" || !r.Registry.matchDomains([]string{pattern}, currentUrl) {
+			continue
+		}
+		for placeholder, secret := range secrets {
+			applicable[placeholder] = secret
+		}
+	}
+	return applicable
+}
+
+// Replace <secret>placeholder</secret> in all string values of params with the real sensitive data
+// applicable to currentUrl. Reports whether any placeholder was replaced.
+func (r *Registry) replaceSensitiveData(
+	params interface{},
+	sensitiveData map[string]string,
+	domainSensitiveData map[string]map[string]string,
+	currentUrl string,
+) (interface{}, bool) {
+	applicable := r.applicableSensitiveData(sensitiveData, domainSensitiveData, currentUrl)
+	allSecrets := utils.FlattenSensitiveData(sensitiveData, domainSensitiveData)
+	missing := map[string]struct{}{}
+	refused := map[string]struct{}{}
+	replaced := false
+
+	var replace func(value interface{}) interface{}
+	replace = func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case string:
+			return secretPattern.ReplaceAllStringFunc(v, func(match string) string {
+				placeholder := secretPattern.FindStringSubmatch(match)[1]
+				if secret, ok := applicable[placeholder]; ok && secret != "" {
+					replaced = true
+					return secret
+				}
+				if secret, ok := allSecrets[placeholder]; ok && secret != "" {
+					refused[placeholder] = struct{}{}
+				} else {
+					missing[placeholder] = struct{}{}
+				}
+				return match
+			})
+		case map[string]interface{}:
+			for k, val := range v {
+				v[k] = replace(val)
+			}
+			return v
+		case []interface{}:
+			for i, val := range v {
+				v[i] = replace(val)
+			}
+			return v
+		default:
+			return v
+		}
+	}
+	params = replace(params)
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		log.Warnf("Missing or empty keys in sensitive_data dictionary: %s", strings.Join(names, ", "))
+	}
+	if len(refused) > 0 {
+		names := make([]string, 0, len(refused))
+		for name := range refused {
+			names = append(names, name)
+		}
+		log.Warnf("🔒  Refused to use sensitive data %s on %s: url does not match the allowed domains", strings.Join(names, ", "), currentUrl)
+	}
+	return params, replaced
+}
+
 // Execute a registered action
-// TODO(LOW): support Context
 func (r *Registry) ExecuteAction(
 	actionName string,
 	argumentsInJson string,
@@ -67,15 +168,32 @@ func (r *Registry) ExecuteAction(
 	pageExtractionLlm model.ToolCallingChatModel,
 	sensitiveData map[string]string,
 	availableFilePaths []string,
-	/*context Context*/) (string, error) {
+) (string, error) {
+	return r.ExecuteActionContext(context.Background(), actionName, argumentsInJson, browser, pageExtractionLlm, sensitiveData, availableFilePaths)
+}
+
+// Execute a registered action with a context. The browser, llm and file paths are passed to the action as values of ctx.
+// An action is not started once ctx is done, an action which already started decides itself whether to stop early.
+// Domain scoped secrets are taken from ctx, see WithDomainSensitiveData.
+func (r *Registry) ExecuteActionContext(
+	ctx context.Context,
+	actionName string,
+	argumentsInJson string,
+	browser *browser.BrowserContext,
+	pageExtractionLlm model.ToolCallingChatModel,
+	sensitiveData map[string]string,
+	availableFilePaths []string,
+) (string, error) {
 
 	// ex) actionName: "ClickElementAction"
 	action, ok := r.Registry.Actions[actionName]
 	if !ok {
 		return "", errors.New("action not found")
 	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 
-	ctx := context.Background()
 	if browser != nil {
 		ctx = context.WithValue(ctx, browserKey, browser)
 	}
@@ -86,10 +204,29 @@ func (r *Registry) ExecuteAction(
 		ctx = context.WithValue(ctx, availableFilePathsKey, availableFilePaths)
 	}
 
-	// TODO(HIGH): replace sensitive data
-	// if sensitive_data {
-	// 	validated_params = self._replace_sensitive_data(validated_params, sensitive_data)
-	// }
+	domainSensitiveData := getDomainSensitiveData(ctx)
+	if len(sensitiveData) > 0 || len(domainSensitiveData) > 0 {
+		var params interface{}
+		if err := json.Unmarshal([]byte(argumentsInJson), &params); err != nil {
+			return "", err
+		}
+		currentUrl := ""
+		if browser != nil {
+			if page, err := browser.GetCurrentPage(); err == nil {
+				currentUrl = page.URL()
+			}
+		}
+		replaced, hasSensitiveData := r.replaceSensitiveData(params, sensitiveData, domainSensitiveData, currentUrl)
+		if hasSensitiveData {
+			b, err := json.Marshal(replaced)
+			if err != nil {
+				return "", err
+			}
+			argumentsInJson = string(b)
+			ctx = context.WithValue(ctx, hasSensitiveDataKey, true)
+		}
+	}
+
 	// Check if the action requires browser
 	// if !slices.Contains(parameterNames, "context") && context == nil {
 	// 	return nil, errors.New("action requires context but none provided")
@@ -3,11 +3,13 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/url"
-	"path/filepath"
 	"strings"
 
+	"github.com/nerdface-ai/browser-use-go/internals/utils"
+
+	"github.com/charmbracelet/log"
 	"github.com/cloudwego/eino/components/tool"
 	einoUtils "github.com/cloudwego/eino/components/tool/utils"
 	"github.com/playwright-community/playwright-go"
@@ -61,11 +63,11 @@ param_model:
 Search for text:
 {search: {'query': {'type': 'string'}, 'case_sensitive': {'type': 'boolean'}}}
 */
-func (ra *RegisteredAction) PromptDescription() string {
+func (ra *RegisteredAction) PromptDescription() (string, error) {
 	// Get a description of the action for the prompt
 	toolInfo, err := (*ra.Tool).Info(context.Background())
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	name := toolInfo.Name
 	desc := toolInfo.Desc
@@ -74,16 +76,33 @@ func (ra *RegisteredAction) PromptDescription() string {
 	fmtObj := make(map[string]interface{})
 	schema, err := toolInfo.ToOpenAPIV3()
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	properties := schema.Properties
 	fmtObj[name] = properties
 	json, err := json.Marshal(fmtObj)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	s += string(json)
-	return s
+	return s, nil
+}
+
+// Get the JSON schema of the action parameters
+func (ra *RegisteredAction) ParamsSchema() (string, error) {
+	toolInfo, err := (*ra.Tool).Info(context.Background())
+	if err != nil {
+		return "", err
+	}
+	schema, err := toolInfo.ToOpenAPIV3()
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
 }
 
 // Base model for dynamically created action models
@@ -96,6 +115,34 @@ type ActionModel struct {
 	Actions map[string]*RegisteredAction `json:"actions"`
 }
 
+// Validate an action against the parameter schema of its registered action
+func (am *ActionModel) ValidateAction(action *ActModel) error {
+	if action == nil || len(*action) != 1 {
+		return errors.New("action must contain exactly one action name")
+	}
+	for name, params := range *action {
+		registered, ok := am.Actions[name]
+		if !ok {
+			return fmt.Errorf("action %s is not registered", name)
+		}
+		paramJson, ok := params.(map[string]interface{})
+		if !ok {
+			if params != nil {
+				return fmt.Errorf("parameters of action %s must be an object", name)
+			}
+			paramJson = map[string]interface{}{}
+		}
+		schema, err := registered.ParamsSchema()
+		if err != nil {
+			return err
+		}
+		if err := ValidateSchema(schema, paramJson); err != nil {
+			return fmt.Errorf("invalid parameters for action %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
 type ActModel map[string]interface{}
 
 // Get the index of the action
@@ -106,7 +153,12 @@ func (am *ActModel) GetIndex() *int {
 			continue
 		}
 		if index, ok := paramJson["index"]; ok {
-			if indexInt, ok := index.(int); ok {
+			switch v := index.(type) {
+			case int:
+				return &v
+			case float64:
+				// actions decoded from JSON carry numbers as float64
+				indexInt := int(v)
 				return &indexInt
 			}
 		}
@@ -128,6 +180,24 @@ func (am *ActModel) SetIndex(index int) {
 	}
 }
 
+// Copy returns a copy of the action whose parameter maps can be modified
+// without touching the original, e.g. before overwriting the index.
+func (am *ActModel) Copy() *ActModel {
+	copied := ActModel{}
+	for key, params := range *am {
+		if paramJson, ok := params.(map[string]interface{}); ok {
+			paramCopy := make(map[string]interface{}, len(paramJson))
+			for k, v := range paramJson {
+				paramCopy[k] = v
+			}
+			copied[key] = paramCopy
+			continue
+		}
+		copied[key] = params
+	}
+	return &copied
+}
+
 // Model representing the action registry
 type ActionRegistry struct {
 	Actions map[string]*RegisteredAction
@@ -139,35 +209,16 @@ func NewActionRegistry() *ActionRegistry {
 	}
 }
 
+// Match the url against domain patterns, see utils.MatchUrlWithDomainPattern. No patterns or no url always match.
 func (ar *ActionRegistry) matchDomains(domains []string, urlStr string) bool {
 	if len(domains) == 0 || urlStr == "" {
 		return true
 	}
-
-	// Parse the URL to get the domain
-	parsedURL, err := url.Parse(urlStr)
-	if err != nil {
-		return false
-	}
-
-	if parsedURL.Host == "" {
-		return false
-	}
-
-	domain := parsedURL.Host
-	// Remove port if present
-	if colonIndex := strings.Index(domain, ":"); colonIndex >= 0 {
-		domain = domain[:colonIndex]
-	}
-
-	// Match domain against patterns
 	for _, domainPattern := range domains {
-		matched, err := filepath.Match(domainPattern, domain)
-		if err == nil && matched {
+		if utils.MatchUrlWithDomainPattern(urlStr, domainPattern) {
 			return true
 		}
 	}
-
 	return false
 }
 
@@ -191,13 +242,13 @@ func (ar *ActionRegistry) GetPromptDescription(page playwright.Page) string {
 			- If page is provided: return only filtered actions that match the current page (excluding unfiltered actions)
 	*/
 	if page == nil {
-		var descriptions []string
+		var unfilteredActions []*RegisteredAction
 		for _, action := range ar.Actions {
 			if action.PageFilter == nil && len(action.Domains) == 0 {
-				descriptions = append(descriptions, action.PromptDescription())
+				unfilteredActions = append(unfilteredActions, action)
 			}
 		}
-		return strings.Join(descriptions, "\n")
+		return promptDescriptions(unfilteredActions)
 	}
 
 	// only include filtered actions for the current page
@@ -215,9 +266,19 @@ func (ar *ActionRegistry) GetPromptDescription(page playwright.Page) string {
 		}
 	}
 
+	return promptDescriptions(filteredActions)
+}
+
+// Join the descriptions of the actions, actions without a valid description are left out
+func promptDescriptions(actions []*RegisteredAction) string {
 	var descriptions []string
-	for _, action := range filteredActions {
-		descriptions = append(descriptions, action.PromptDescription())
+	for _, action := range actions {
+		description, err := action.PromptDescription()
+		if err != nil {
+			log.Errorf("Failed to describe action: %s", err)
+			continue
+		}
+		descriptions = append(descriptions, description)
 	}
 	return strings.Join(descriptions, "\n")
 }
@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/nerdface-ai/browser-use-go/internals/utils"
 	"github.com/nerdface-ai/browser-use-go/pkg/browser"
 
 	"github.com/charmbracelet/log"
@@ -49,6 +52,18 @@ func getBrowserContext(ctx context.Context) (*browser.BrowserContext, error) {
 	return nil, errors.New("browserContext is not found")
 }
 
+func getAvailableFilePaths(ctx context.Context) []string {
+	if paths, ok := ctx.Value(availableFilePathsKey).([]string); ok {
+		return paths
+	}
+	return nil
+}
+
+func hasSensitiveData(ctx context.Context) bool {
+	has, ok := ctx.Value(hasSensitiveDataKey).(bool)
+	return ok && has
+}
+
 type Controller struct {
 	Registry *Registry
 }
@@ -75,6 +90,7 @@ func NewController() *Controller {
 	RegisterAction(c, "scroll_to_text", "If you dont find something which you want to interact with, scroll to it", c.ScrollToText, []string{}, nil)
 	RegisterAction(c, "get_dropdown_options", "Get all options from a native dropdown", c.GetDropdownOptions, []string{}, nil)
 	RegisterAction(c, "select_dropdown_option", "Select dropdown option for interactive element index by the text of the option you want to select", c.SelectDropdownOption, []string{}, nil)
+	RegisterAction(c, "upload_file", "Upload file to interactive element with file path", c.UploadFile, []string{}, nil)
 	RegisterAction(c, "drag_drop", "Drag and drop elements or between coordinates on the page - useful for canvas drawing, sortable lists, sliders, file uploads, and UI rearrangement", c.DragDrop, []string{}, nil)
 	return c
 }
@@ -100,15 +116,31 @@ func (c *Controller) ExecuteAction(
 	pageExtractionLlm model.ToolCallingChatModel,
 	sensitiveData map[string]string,
 	availableFilePaths []string,
-	// context: Context | None,
+) (*ActionResult, error) {
+	return c.ExecuteActionContext(context.Background(), action, browserContext, pageExtractionLlm, sensitiveData, availableFilePaths)
+}
+
+// ExecuteActionContext is ExecuteAction with a context, which is passed on to the action.
+// Domain scoped secrets are taken from ctx, see WithDomainSensitiveData.
+func (c *Controller) ExecuteActionContext(
+	ctx context.Context,
+	action *ActModel,
+	browserContext *browser.BrowserContext,
+	pageExtractionLlm model.ToolCallingChatModel,
+	sensitiveData map[string]string,
+	availableFilePaths []string,
 ) (*ActionResult, error) {
 	for actionName, actionParams := range *action {
 		ab, err := json.Marshal(actionParams)
 		if err != nil {
 			return nil, err
 		}
-		result, err := c.Registry.ExecuteAction(actionName, string(ab), browserContext, pageExtractionLlm, sensitiveData, availableFilePaths)
+		result, err := c.Registry.ExecuteActionContext(ctx, actionName, string(ab), browserContext, pageExtractionLlm, sensitiveData, availableFilePaths)
+		secrets := utils.FlattenSensitiveData(sensitiveData, getDomainSensitiveData(ctx))
 		if err != nil {
+			if len(secrets) > 0 {
+				return nil, errors.New(utils.FilterSensitiveData(err.Error(), secrets))
+			}
 			return nil, err
 		}
 		var actionResult ActionResult
@@ -116,6 +148,13 @@ func (c *Controller) ExecuteAction(
 		if err != nil {
 			return nil, err
 		}
+		// never hand the real sensitive values back to the agent
+		if actionResult.ExtractedContent != nil {
+			actionResult.ExtractedContent = playwright.String(utils.FilterSensitiveData(*actionResult.ExtractedContent, secrets))
+		}
+		if actionResult.Error != nil {
+			actionResult.Error = playwright.String(utils.FilterSensitiveData(*actionResult.Error, secrets))
+		}
 		return &actionResult, nil
 	}
 	return NewActionResult(), nil
@@ -136,7 +175,10 @@ func (c *Controller) ClickElementByIndex(ctx context.Context, params ClickElemen
 	if err != nil {
 		return nil, err
 	}
-	session := bc.GetSession()
+	session, err := bc.GetSession()
+	if err != nil {
+		return nil, err
+	}
 	initialPages := len(session.Context.Pages())
 
 	elementNode, err := bc.GetDomElementByIndex(params.Index)
@@ -146,7 +188,7 @@ func (c *Controller) ClickElementByIndex(ctx context.Context, params ClickElemen
 
 	// if element has file uploader then dont click
 	if bc.IsFileUploader(elementNode, 3, 0) {
-		msg := fmt.Sprintf("Index %d - has an element which opens file upload dialog. To upload files please use a specific function to upload files", params.Index)
+		msg := fmt.Sprintf("Index %d - has an element which opens file upload dialog. To upload files please use the upload_file action", params.Index)
 		log.Info(msg)
 		actionResult := NewActionResult()
 		actionResult.ExtractedContent = &msg
@@ -154,7 +196,6 @@ func (c *Controller) ClickElementByIndex(ctx context.Context, params ClickElemen
 		return actionResult, nil
 	}
 
-	// TODO(HIGH): support download path in ClickElementNode
 	downloadPath, err := bc.ClickElementNode(elementNode)
 	if err != nil {
 		return nil, err
@@ -167,14 +208,18 @@ func (c *Controller) ClickElementByIndex(ctx context.Context, params ClickElemen
 		msg = fmt.Sprintf("🖱️  Clicked button with index %d: %s", params.Index, elementNode.GetAllTextTillNextClickableElement(-1))
 	}
 
+	actionResult := NewActionResult()
 	if len(session.Context.Pages()) > initialPages {
 		newTabMsg := "New tab opened - switching to it"
 		msg += " - " + newTabMsg
 		log.Debug(newTabMsg)
-		bc.SwitchToTab(-1)
+		if err := bc.SwitchToTab(-1); err != nil {
+			errorMsg := fmt.Sprintf("Failed to switch to the new tab: %s", err.Error())
+			log.Warn(errorMsg)
+			actionResult.Error = &errorMsg
+		}
 	}
 
-	actionResult := NewActionResult()
 	actionResult.ExtractedContent = &msg
 	actionResult.IncludeInMemory = true
 
@@ -186,18 +231,18 @@ func (c *Controller) InputText(ctx context.Context, params InputTextAction) (*Ac
 	if err != nil {
 		return nil, err
 	}
-	selectorMap := bc.GetSelectorMap()
-	if (*selectorMap)[params.Index] == nil {
-		return nil, errors.New("element with index " + strconv.Itoa(params.Index) + " does not exist")
-	}
-
 	elementNode, err := bc.GetDomElementByIndex(params.Index)
 	if err != nil {
 		return nil, err
 	}
-	bc.InputTextElementNode(elementNode, params.Text)
+	if err := bc.InputTextElementNode(elementNode, params.Text); err != nil {
+		return nil, fmt.Errorf("failed to input text into index %d: %w", params.Index, err)
+	}
 
 	msg := fmt.Sprintf("Input %s into index %d", params.Text, params.Index)
+	if hasSensitiveData(ctx) {
+		msg = fmt.Sprintf("Input sensitive data into index %d", params.Index)
+	}
 
 	actionResult := NewActionResult()
 	actionResult.ExtractedContent = &msg
@@ -206,14 +251,42 @@ func (c *Controller) InputText(ctx context.Context, params InputTextAction) (*Ac
 	return actionResult, nil
 }
 
+func (c *Controller) UploadFile(ctx context.Context, params UploadFileAction) (*ActionResult, error) {
+	bc, err := getBrowserContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(getAvailableFilePaths(ctx), params.Path) {
+		return nil, fmt.Errorf("file path %s is not available", params.Path)
+	}
+	if _, err := os.Stat(params.Path); err != nil {
+		return nil, fmt.Errorf("file %s does not exist", params.Path)
+	}
+
+	elementNode, err := bc.GetDomElementByIndex(params.Index)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.UploadFileToElementNode(elementNode, params.Path); err != nil {
+		return nil, fmt.Errorf("failed to upload file to index %d: %w", params.Index, err)
+	}
+
+	msg := fmt.Sprintf("📁  Successfully uploaded file %s to index %d", filepath.Base(params.Path), params.Index)
+	log.Info(msg)
+	actionResult := NewActionResult()
+	actionResult.ExtractedContent = &msg
+	actionResult.IncludeInMemory = true
+	return actionResult, nil
+}
+
 func (c *Controller) SearchGoogle(ctx context.Context, params SearchGoogleAction) (*ActionResult, error) {
 	bc, err := getBrowserContext(ctx)
 	if err != nil {
 		return nil, err
 	}
-	page := bc.GetCurrentPage()
-	page.Goto(fmt.Sprintf("https://www.google.com/search?q=%s&udm=14", params.Query))
-	page.WaitForLoadState()
+	if err := bc.NavigateTo(fmt.Sprintf("https://www.google.com/search?q=%s&udm=14", params.Query)); err != nil {
+		return nil, err
+	}
 	msg := fmt.Sprintf("🔍  Searched for \"%s\" in Google", params.Query)
 	log.Debug(msg)
 	actionResult := NewActionResult()
@@ -227,9 +300,9 @@ func (c *Controller) GoToUrl(ctx context.Context, params GoToUrlAction) (*Action
 	if err != nil {
 		return nil, err
 	}
-	page := bc.GetCurrentPage()
-	page.Goto(params.Url)
-	page.WaitForLoadState()
+	if err := bc.NavigateTo(params.Url); err != nil {
+		return nil, err
+	}
 	msg := fmt.Sprintf("🔗  Navigated to %s", params.Url)
 	log.Debug(msg)
 	actionResult := NewActionResult()
@@ -255,7 +328,9 @@ func (c *Controller) GoBack(ctx context.Context, params GoBackAction) (*ActionRe
 func (c *Controller) Wait(ctx context.Context, params WaitAction) (*ActionResult, error) {
 	msg := fmt.Sprintf("🕒  Waiting for %d seconds", params.Seconds)
 	log.Debug(msg)
-	time.Sleep(time.Duration(params.Seconds) * time.Second)
+	if err := utils.Sleep(ctx, time.Duration(params.Seconds)*time.Second); err != nil {
+		return nil, err
+	}
 	actionResult := NewActionResult()
 	actionResult.ExtractedContent = &msg
 	actionResult.IncludeInMemory = true
@@ -267,7 +342,10 @@ func (c *Controller) SavePdf(ctx context.Context, params SavePdfAction) (*Action
 	if err != nil {
 		return nil, err
 	}
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 	shortUrl := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(page.URL(), "https://", ""), "http://", ""), "www.", ""), "/", "")
 	slug := strings.ToLower(strings.ReplaceAll(shortUrl, "[^a-zA-Z0-9]+", "-"))
 	sanitizedFilename := fmt.Sprintf("%s.pdf", slug)
@@ -305,8 +383,18 @@ func (c *Controller) CloseTab(ctx context.Context, params CloseTabAction) (*Acti
 	if err != nil {
 		return nil, err
 	}
-	bc.SwitchToTab(params.PageId)
-	page := bc.GetCurrentPage()
+	if err := bc.SwitchToTab(params.PageId); err != nil {
+		// the current page is not the one to close
+		errorMsg := fmt.Sprintf("Failed to close tab %d: %s", params.PageId, err.Error())
+		actionResult := NewActionResult()
+		actionResult.Error = &errorMsg
+		actionResult.IncludeInMemory = true
+		return actionResult, nil
+	}
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 	page.WaitForLoadState()
 	url := page.URL()
 	err = page.Close()
@@ -326,8 +414,17 @@ func (c *Controller) SwitchTab(ctx context.Context, params SwitchTabAction) (*Ac
 	if err != nil {
 		return nil, err
 	}
-	bc.SwitchToTab(params.PageId)
-	page := bc.GetCurrentPage()
+	if err := bc.SwitchToTab(params.PageId); err != nil {
+		errorMsg := fmt.Sprintf("Failed to switch to tab %d: %s", params.PageId, err.Error())
+		actionResult := NewActionResult()
+		actionResult.Error = &errorMsg
+		actionResult.IncludeInMemory = true
+		return actionResult, nil
+	}
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 	page.WaitForLoadState()
 	msg := fmt.Sprintf("🔄  Switched to tab %d", params.PageId)
 	log.Debug(msg)
@@ -348,7 +445,10 @@ func (c *Controller) ExtractContent(ctx context.Context, params ExtractContentAc
 	} else {
 		return nil, errors.New("page_extraction_llm is not found")
 	}
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 
 	strip := []string{}
 	if params.ShouldStripLinkUrls {
@@ -392,7 +492,7 @@ func (c *Controller) ExtractContent(ctx context.Context, params ExtractContentAc
 	}
 
 	prompt := fmt.Sprintf("Your task is to extract the content of the page. You will be given a page and a goal and you should extract all relevant information around this goal from the page. If the goal is vague, summarize the page. Respond in json format. Extraction goal: %s, Page: %s", params.Goal, content)
-	output, err := llm.Generate(context.Background(), []*schema.Message{{Role: schema.User, Content: prompt}})
+	output, err := llm.Generate(ctx, []*schema.Message{{Role: schema.User, Content: prompt}})
 	if err != nil {
 		log.Debug("Error extracting content: %s", err)
 		msg := fmt.Sprintf("📄  Extracted from page\n: %s\n", content)
@@ -415,7 +515,10 @@ func (c *Controller) ScrollDown(ctx context.Context, params ScrollDownAction) (*
 		return nil, err
 	}
 
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 	amount := "one page"
 	if params.Amount != nil {
 		page.Evaluate(fmt.Sprintf("window.scrollBy(0, %d);", *params.Amount))
@@ -436,7 +539,10 @@ func (c *Controller) ScrollUp(ctx context.Context, params ScrollUpAction) (*Acti
 	if err != nil {
 		return nil, err
 	}
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 	var amount string
 	if params.Amount != nil {
 		page.Evaluate(fmt.Sprintf("window.scrollBy(0, -%d);", *params.Amount))
@@ -459,7 +565,10 @@ func (c *Controller) SendKeys(ctx context.Context, params SendKeysAction) (*Acti
 		return nil, err
 	}
 
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 	err = page.Keyboard().InsertText(params.Keys)
 	if err != nil {
 		if strings.Contains(err.Error(), "Unknown key") {
@@ -474,6 +583,9 @@ func (c *Controller) SendKeys(ctx context.Context, params SendKeysAction) (*Acti
 		}
 	}
 	msg := fmt.Sprintf("⌨️  Sent keys: %s", params.Keys)
+	if hasSensitiveData(ctx) {
+		msg = "⌨️  Sent sensitive keys"
+	}
 	log.Debug(msg)
 	actionResult := NewActionResult()
 	actionResult.ExtractedContent = &msg
@@ -486,7 +598,10 @@ func (c *Controller) ScrollToText(ctx context.Context, params ScrollToTextAction
 	if err != nil {
 		return nil, err
 	}
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 	// Try different locator strategies
 	locators := []playwright.Locator{
 		page.GetByText(params.Text, playwright.PageGetByTextOptions{Exact: playwright.Bool(false)}),
@@ -524,9 +639,14 @@ func (c *Controller) GetDropdownOptions(ctx context.Context, params GetDropdownO
 	if err != nil {
 		return nil, err
 	}
-	page := bc.GetCurrentPage()
-	selectorMap := bc.GetSelectorMap()
-	domElement := (*selectorMap)[params.Index]
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
+	domElement, err := bc.GetDomElementByIndex(params.Index)
+	if err != nil {
+		return nil, err
+	}
 
 	// Frame-aware approach since we know it works
 	allOptions := []string{}
@@ -551,17 +671,12 @@ func (c *Controller) GetDropdownOptions(ctx context.Context, params GetDropdownO
 		if err != nil {
 			log.Debug(fmt.Sprintf("Frame %d evaluation failed: %s", frameIndex, err.Error()))
 		}
-		if options != nil {
+		if dropdown, ok := options.(map[string]interface{}); ok {
 			log.Debug(fmt.Sprintf("Found dropdown in frame %d", frameIndex))
-			log.Debug(fmt.Sprintf("Dropdown ID: %s, Name: %s", options.(map[string]interface{})["id"], options.(map[string]interface{})["name"]))
-
-			formattedOptions := []string{}
-			for _, opt := range options.(map[string]interface{})["options"].([]interface{}) {
-				// encoding ensures AI uses the exact string in select_dropdown_option
-				encodedText, _ := json.Marshal(opt.(map[string]interface{})["text"])
-				formattedOptions = append(formattedOptions, fmt.Sprintf("%d: text=%s", opt.(map[string]interface{})["index"], encodedText))
-			}
-			allOptions = append(allOptions, formattedOptions...)
+			log.Debug(fmt.Sprintf("Dropdown ID: %v, Name: %v", dropdown["id"], dropdown["name"]))
+			allOptions = append(allOptions, formatDropdownOptions(dropdown)...)
+		} else if options != nil {
+			log.Debug(fmt.Sprintf("Frame %d returned unexpected dropdown options: %v", frameIndex, options))
 		}
 		frameIndex += 1
 	}
@@ -584,15 +699,40 @@ func (c *Controller) GetDropdownOptions(ctx context.Context, params GetDropdownO
 	}
 }
 
+// Format the options of a dropdown as "index: text=\"text\"", options of an unexpected shape are skipped
+func formatDropdownOptions(dropdown map[string]interface{}) []string {
+	options, ok := dropdown["options"].([]interface{})
+	if !ok {
+		return nil
+	}
+	formattedOptions := []string{}
+	for _, opt := range options {
+		option, ok := opt.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// encoding ensures AI uses the exact string in select_dropdown_option
+		encodedText, _ := json.Marshal(option["text"])
+		index := int(utils.GetNumberValue(option, "index", float64(len(formattedOptions))))
+		formattedOptions = append(formattedOptions, fmt.Sprintf("%d: text=%s", index, encodedText))
+	}
+	return formattedOptions
+}
+
 func (c *Controller) SelectDropdownOption(ctx context.Context, params SelectDropdownOptionAction) (*ActionResult, error) {
 	bc, err := getBrowserContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	page := bc.GetCurrentPage()
-	selectorMap := bc.GetSelectorMap()
-	domElement := (*selectorMap)[params.Index]
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
+	domElement, err := bc.GetDomElementByIndex(params.Index)
+	if err != nil {
+		return nil, err
+	}
 	text := params.Text
 
 	if domElement.TagName != "select" {
@@ -684,7 +824,10 @@ func (c *Controller) DragDrop(ctx context.Context, params DragDropAction) (*Acti
 	if err != nil {
 		return nil, err
 	}
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 
 	// Initialize variables
 	var sourceX *int = nil
@@ -2,8 +2,9 @@ package controller
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/invopop/jsonschema"
 	"github.com/xeipuuv/gojsonschema"
@@ -27,7 +28,7 @@ func removeRefRecursive(v interface{}) interface{} {
 	}
 }
 
-func GenerateSchema(typeDefinition interface{}) string {
+func GenerateSchema(typeDefinition interface{}) (string, error) {
 	var modelName string
 	if reflect.TypeOf(typeDefinition).Kind() == reflect.Ptr {
 		modelName = reflect.TypeOf(typeDefinition).Elem().Name()
@@ -35,22 +36,26 @@ func GenerateSchema(typeDefinition interface{}) string {
 		modelName = reflect.TypeOf(typeDefinition).Name()
 	}
 	s := jsonschema.Reflect(typeDefinition)
-	s.Definitions[modelName].Title = modelName
-	b, err := json.Marshal(s.Definitions[modelName])
+	definition, ok := s.Definitions[modelName]
+	if !ok {
+		return "", fmt.Errorf("no schema definition for %q, only named struct types are supported", modelName)
+	}
+	definition.Title = modelName
+	b, err := json.Marshal(definition)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	var m map[string]interface{}
 	if err := json.Unmarshal(b, &m); err != nil {
-		panic(err)
+		return "", err
 	}
 	// remove all $ref
-	m = removeRefRecursive(m).(map[string]interface{})
+	removeRefRecursive(m)
 	b2, err := json.MarshalIndent(m, "", "  ")
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return string(b2)
+	return string(b2), nil
 }
 
 func ValidateSchema(schemaString string, data map[string]interface{}) error {
@@ -66,5 +71,9 @@ func ValidateSchema(schemaString string, data map[string]interface{}) error {
 	if validResult.Valid() {
 		return nil
 	}
-	return errors.New("invalid schema")
+	var problems []string
+	for _, resultErr := range validResult.Errors() {
+		problems = append(problems, resultErr.String())
+	}
+	return fmt.Errorf("invalid schema: %s", strings.Join(problems, "; "))
 }
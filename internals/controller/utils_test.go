@@ -1,8 +1,14 @@
 package controller
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/nerdface-ai/browser-use-go/pkg/browser"
 )
 
 func TestTypeName(t *testing.T) {
@@ -13,13 +19,24 @@ func TestTypeName(t *testing.T) {
 }
 
 func TestGenerateSchema(t *testing.T) {
-	t.Log(GenerateSchema(&InputTextAction{}))
-	t.Log(GenerateSchema(&NoParamsAction{}))
+	for _, action := range []interface{}{&InputTextAction{}, &NoParamsAction{}} {
+		schemaString, err := GenerateSchema(action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(schemaString)
+	}
+	if _, err := GenerateSchema(map[string]string{}); err == nil {
+		t.Error("expected an error for a type without a schema definition")
+	}
 }
 
 func TestValidateSchema(t *testing.T) {
-	schemaString := GenerateSchema(&InputTextAction{})
-	err := ValidateSchema(schemaString, map[string]interface{}{"index": 3, "text": "GPU compiler"})
+	schemaString, err := GenerateSchema(&InputTextAction{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateSchema(schemaString, map[string]interface{}{"index": 3, "text": "GPU compiler"})
 	if err != nil {
 		t.Error(err)
 		t.Log("InputTextAction validate test failed")
@@ -27,7 +44,10 @@ func TestValidateSchema(t *testing.T) {
 		t.Log("InputTextAction validate test success")
 	}
 
-	schemaString = GenerateSchema(&DoneAction{})
+	schemaString, err = GenerateSchema(&DoneAction{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	err = ValidateSchema(schemaString, map[string]interface{}{"text": "Here are recommended books related to GPU compilers that you can read on Amazon Kindle:\n\n1. 'Die CUDA-Programmierung mit C++ meistern: Eine umfassende Einführung (German Edition)' by Jamie Flux – A comprehensive introduction to CUDA programming with C++. (German language, Kindle Edition available)\n\n2. 'Languages and Compilers for Parallel Computing: 28th International Workshop, LCPC 2015' by Xipeng Shen, Frank Mueller et al. – Research collection on languages and compilers for parallel computing, including topics relevant to GPU compilation. (English, Kindle Edition available)\n\n3. 'Languages and Compilers for Parallel Computing: 29th International Workshop, LCPC 2016' by Chen Ding, John Criswell et al. – Proceedings from a major parallel computing workshop, with research applicable to GPU and compiler technology. (English, Kindle Edition available)\n\n4. 'Evolving OpenMP for Evolving Architectures: 14th International Workshop on OpenMP, IWOMP 2018' by Bronis R. de Supinski, Pedro Valero-Lara et al. – Papers on OpenMP and parallel architectures, including content of interest to GPU programming and compilers. (English, Kindle Edition available)\n\nThese titles are relevant for learning about GPU compilers, parallel languages, and related compiler technology. Some more technical or research-focused books are also available in paperback only, but not for Kindle.\n\nTask complete. Success!", "success": true})
 	if err != nil {
 		t.Error(err)
@@ -36,7 +56,10 @@ func TestValidateSchema(t *testing.T) {
 		t.Log("DoneAction validate test success")
 	}
 
-	schemaString = GenerateSchema(&GoToUrlAction{})
+	schemaString, err = GenerateSchema(&GoToUrlAction{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	err = ValidateSchema(schemaString, map[string]interface{}{"url": "https://www.google.com"})
 	if err != nil {
 		t.Error(err)
@@ -45,7 +68,10 @@ func TestValidateSchema(t *testing.T) {
 		t.Log("GoToUrlAction validate test success")
 	}
 
-	schemaString = GenerateSchema(&ClickElementAction{})
+	schemaString, err = GenerateSchema(&ClickElementAction{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	err = ValidateSchema(schemaString, map[string]interface{}{"index": 5})
 	if err != nil {
 		t.Error(err)
@@ -55,7 +81,10 @@ func TestValidateSchema(t *testing.T) {
 	}
 
 	// should be error
-	schemaString = GenerateSchema(&GoToUrlAction{})
+	schemaString, err = GenerateSchema(&GoToUrlAction{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	err = ValidateSchema(schemaString, map[string]interface{}{"index": 5})
 	if err != nil {
 		t.Log("GoToUrlAction & ClickElementAction validate test success")
@@ -64,3 +93,133 @@ func TestValidateSchema(t *testing.T) {
 		t.Log("GoToUrlAction & ClickElementAction validate test failed")
 	}
 }
+
+func TestReplaceSensitiveData(t *testing.T) {
+	registry := NewRegistry()
+	sensitiveData := map[string]string{"x_password": "hunter2", "x_empty": ""}
+
+	params := map[string]interface{}{
+		"index": 3.0,
+		"text":  "<secret>x_password</secret>",
+		"nested": []interface{}{
+			"user <secret>x_password</secret>",
+			"<secret>x_missing</secret>",
+			"<secret>x_empty</secret>",
+		},
+	}
+	replaced, ok := registry.replaceSensitiveData(params, sensitiveData, nil, "https://example.com")
+	if !ok {
+		t.Fatal("expected placeholders to be replaced")
+	}
+	got := replaced.(map[string]interface{})
+	if got["text"] != "hunter2" {
+		t.Errorf("expected text to be replaced, got %v", got["text"])
+	}
+	nested := got["nested"].([]interface{})
+	if nested[0] != "user hunter2" {
+		t.Errorf("expected nested value to be replaced, got %v", nested[0])
+	}
+	if nested[1] != "<secret>x_missing</secret>" || nested[2] != "<secret>x_empty</secret>" {
+		t.Errorf("expected unknown placeholders to be kept, got %v", nested[1:])
+	}
+	if got["index"] != 3.0 {
+		t.Errorf("expected non-string values to be kept, got %v", got["index"])
+	}
+
+	if _, ok := registry.replaceSensitiveData(map[string]interface{}{"text": "plain"}, sensitiveData, nil, "https://example.com"); ok {
+		t.Error("expected no replacement without placeholders")
+	}
+}
+
+func TestReplaceDomainScopedSensitiveData(t *testing.T) {
+	registry := NewRegistry()
+	sensitiveData := map[string]string{"x_user": "alice"}
+	domainSensitiveData := map[string]map[string]string{"https://*.bank.example": {"pin": "1234"}}
+
+	cases := []struct {
+		url      string
+		expected string
+	}{
+		{"https://www.bank.example/login", "alice 1234"},
+		{"http://www.bank.example/login", "alice <secret>pin</secret>"},
+		{"https://evil.example/www.bank.example", "alice <secret>pin</secret>"},
+		{"about:blank", "alice <secret>pin</secret>"},
+		{"", "alice <secret>pin</secret>"},
+	}
+	for _, c := range cases {
+		params := map[string]interface{}{"text": "<secret>x_user</secret> <secret>pin</secret>"}
+		replaced, _ := registry.replaceSensitiveData(params, sensitiveData, domainSensitiveData, c.url)
+		if got := replaced.(map[string]interface{})["text"]; got != c.expected {
+			t.Errorf("url %q: expected %q, got %q", c.url, c.expected, got)
+		}
+	}
+
+	// a domain without wildcard does not scope the secret to its subdomains
+	scoped := map[string]map[string]string{"github.io": {"token": "abc"}}
+	for url, expected := range map[string]string{
+		"https://github.io/login":      "abc",
+		"https://evil.github.io/login": "<secret>token</secret>",
+	} {
+		params := map[string]interface{}{"text": "<secret>token</secret>"}
+		replaced, _ := registry.replaceSensitiveData(params, nil, scoped, url)
+		if got := replaced.(map[string]interface{})["text"]; got != expected {
+			t.Errorf("url %q: expected %q, got %q", url, expected, got)
+		}
+	}
+
+	ctx := WithDomainSensitiveData(context.Background(), domainSensitiveData)
+	if got := getDomainSensitiveData(ctx); got["https://*.bank.example"]["pin"] != "1234" {
+		t.Errorf("expected the domain scoped secrets to be carried by the context, got %v", got)
+	}
+}
+
+func TestUploadFileRejectsUnavailablePath(t *testing.T) {
+	c := NewController()
+	ctx := context.WithValue(context.Background(), browserKey, &browser.BrowserContext{})
+	ctx = context.WithValue(ctx, availableFilePathsKey, []string{"/tmp/allowed.txt"})
+
+	_, err := c.UploadFile(ctx, UploadFileAction{Index: 1, Path: "/etc/passwd"})
+	if err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("expected unavailable path to be rejected, got %v", err)
+	}
+
+	_, err = c.UploadFile(ctx, UploadFileAction{Index: 1, Path: "/tmp/allowed.txt"})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected missing file to be rejected, got %v", err)
+	}
+
+	existing := filepath.Join(t.TempDir(), "upload.txt")
+	if err := os.WriteFile(existing, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx = context.WithValue(ctx, availableFilePathsKey, []string{existing})
+	_, err = c.UploadFile(ctx, UploadFileAction{Index: 1, Path: existing})
+	if err == nil || !strings.Contains(err.Error(), "element with index 1 does not exist") {
+		t.Errorf("expected missing element to be rejected, got %v", err)
+	}
+}
+
+func TestFormatDropdownOptions(t *testing.T) {
+	dropdown := map[string]interface{}{
+		"id": "pets",
+		"options": []interface{}{
+			map[string]interface{}{"text": "Dog", "value": "dog", "index": float64(0)},
+			"not an option",
+			map[string]interface{}{"text": "Cat", "value": "cat", "index": float64(1)},
+		},
+	}
+	got := formatDropdownOptions(dropdown)
+	want := []string{`0: text="Dog"`, `1: text="Cat"`}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %q, got %q", want[i], got[i])
+		}
+	}
+
+	if got := formatDropdownOptions(map[string]interface{}{"options": "none"}); len(got) != 0 {
+		t.Errorf("expected no options for a malformed dropdown, got %v", got)
+	}
+}
@@ -95,6 +95,11 @@ type ExtractPageContentAction struct {
 	Value string `json:"value"`
 }
 
+type UploadFileAction struct {
+	Index int    `json:"index"`
+	Path  string `json:"path"`
+}
+
 type NoParamsAction struct {
 	// Accepts absolutely anything in the incoming data
 	// and discards it, so the final parsed model is empty.
@@ -52,3 +52,53 @@ func TestProcessDOM(t *testing.T) {
 		log.Fatalf("failed to write dom.json: %v", err)
 	}
 }
+
+func TestConstructDomTree(t *testing.T) {
+	s := &DomService{}
+
+	// malformed results of buildDomTree.js are reported instead of panicking
+	for _, evalPage := range []map[string]any{
+		{},
+		{"map": map[string]any{}},
+		{"map": map[string]any{}, "rootId": 1.0},
+		{"map": map[string]any{"1": "not a node"}, "rootId": "1"},
+	} {
+		if _, _, err := s.constructDomTree(evalPage); err == nil {
+			t.Errorf("expected an error for %v", evalPage)
+		}
+	}
+
+	evalPage := map[string]any{
+		"rootId": "1",
+		"map": map[string]any{
+			"1": map[string]any{
+				"tagName":  "body",
+				"xpath":    "/body",
+				"children": []any{"2", "3"},
+				"viewport": map[string]any{"width": 1280.0, "height": 1100.0},
+			},
+			"2": map[string]any{
+				"tagName":        "a",
+				"xpath":          "/body/a",
+				"attributes":     map[string]any{"href": "/"},
+				"highlightIndex": 0,
+				"isVisible":      true,
+			},
+			// missing fields fall back to their zero values
+			"3": map[string]any{"type": "TEXT_NODE"},
+		},
+	}
+	root, selectorMap, err := s.constructDomTree(evalPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.TagName != "body" || len(root.Children) != 2 {
+		t.Errorf("expected body with 2 children, got %s with %d", root.TagName, len(root.Children))
+	}
+	if root.ViewportInfo == nil || root.ViewportInfo.Width != 1280 {
+		t.Errorf("expected viewport width 1280, got %v", root.ViewportInfo)
+	}
+	if link := (*selectorMap)[0]; link == nil || link.Attributes["href"] != "/" {
+		t.Errorf("expected link in selector map, got %v", link)
+	}
+}
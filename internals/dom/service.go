@@ -1,12 +1,12 @@
 package dom
 
 import (
+	_ "embed"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/url"
-	"os"
-	"path/filepath"
-	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -22,27 +22,34 @@ type ViewportShortInfo struct {
 	Height int `json:"height"`
 }
 
+// Ad network and tracker domains
+var DefaultAdDomains = []string{
+	"doubleclick.net",
+	"adroll.com",
+	"googletagmanager.com",
+	"google-analytics.com",
+	"googlesyndication.com",
+	"googleadservices.com",
+}
+
 type DomService struct {
 	Page       playwright.Page `json:"page"`
 	XpathCache map[string]any  `json:"xpathCache"`
 	JsCode     string          `json:"jsCode"`
+	AdDomains  []string        `json:"adDomains"`
 }
 
+// The script building the DOM tree in the page, embedded so it does not depend on the source tree
+//
+//go:embed buildDomTree.js
+var buildDomTreeJs string
+
 func NewDomService(page playwright.Page) *DomService {
-	_, filename, _, ok := runtime.Caller(0)
-	if !ok {
-		panic("failed to get pwd")
-	}
-	dirname := filepath.Dir(filename)
-	jsPath := filepath.Join(dirname, "buildDomTree.js")
-	jsCode, err := os.ReadFile(jsPath)
-	if err != nil {
-		panic(err)
-	}
 	return &DomService{
 		Page:       page,
 		XpathCache: make(map[string]any),
-		JsCode:     string(jsCode),
+		JsCode:     buildDomTreeJs,
+		AdDomains:  DefaultAdDomains,
 	}
 }
 
@@ -60,11 +67,11 @@ func (s *DomService) GetClickableElements(highlightElements bool, focusElement i
 
 func (s *DomService) GetCrossOriginIframes() []string {
 	// invisible cross-origin iframes are used for ads and tracking, dont open those
-	hiddenFrameUrls, _ := s.Page.Locator("iframe").Filter(playwright.LocatorFilterOptions{Visible: playwright.Bool(false)}).EvaluateAll("e => e.map(e => e.src)")
+	hiddenFrames, _ := s.Page.Locator("iframe").Filter(playwright.LocatorFilterOptions{Visible: playwright.Bool(false)}).EvaluateAll("e => e.map(e => e.src)")
+	hiddenFrameUrls := utils.ConvertToSliceOfString(hiddenFrames)
 
-	var adDomains = []string{"doubleclick.net", "adroll.com", "googletagmanager.com"}
 	isAdUrl := func(url string) bool {
-		for _, domain := range adDomains {
+		for _, domain := range s.AdDomains {
 			if strings.Contains(url, domain) {
 				return true
 			}
@@ -94,7 +101,7 @@ func (s *DomService) GetCrossOriginIframes() []string {
 			continue
 		}
 		// Exclude hidden frames
-		if _, exists := hiddenFrameUrls.(map[string]any)[frameUrl]; exists {
+		if slices.Contains(hiddenFrameUrls, frameUrl) {
 			continue
 		}
 		// Exclude ad network tracker frame URLs
@@ -108,16 +115,12 @@ func (s *DomService) GetCrossOriginIframes() []string {
 }
 
 func (s *DomService) buildDomTree(highlightElements bool, focusElement int, viewportExpansion int) (*DOMElementNode, *SelectorMap, error) {
+	// check that the page can evaluate JS at all
 	result, err := s.Page.Evaluate("1+1")
-	errorOccured := false
 	if err != nil {
-		errorOccured = true
-	} else if resultValue, ok := result.(float64); ok {
-		if resultValue != 2 {
-			errorOccured = true
-		}
+		return nil, nil, fmt.Errorf("failed to evaluate JS: %w", err)
 	}
-	if errorOccured {
+	if resultValue, ok := result.(float64); ok && resultValue != 2 {
 		return nil, nil, errors.New("failed to evaluate JS")
 	}
 
@@ -141,12 +144,12 @@ func (s *DomService) buildDomTree(highlightElements bool, focusElement int, view
 	}
 	evalPage, err := s.Page.Evaluate(s.JsCode, args)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("failed to build DOM tree: %w", err)
 	}
 
 	evalPageMap, ok := evalPage.(map[string]any)
 	if !ok {
-		return nil, nil, errors.New("failed to cast evalPage to map[string]any")
+		return nil, nil, fmt.Errorf("unexpected DOM tree of type %T", evalPage)
 	}
 
 	if debugMode && evalPageMap["perfMetrics"] != nil {
@@ -163,9 +166,13 @@ func (s *DomService) buildDomTree(highlightElements bool, focusElement int, view
 func (s *DomService) constructDomTree(evalPage map[string]any) (*DOMElementNode, *SelectorMap, error) {
 	jsNodeMap, ok := evalPage["map"].(map[string]any)
 	if !ok {
-		return nil, nil, errors.New("failed to cast map[string]any to map[string]any")
+		return nil, nil, errors.New("DOM tree has no node map")
+	}
+	rootId, ok := evalPage["rootId"].(string)
+	if !ok {
+		return nil, nil, errors.New("DOM tree has no root id")
 	}
-	jsRootId, err := strconv.Atoi(evalPage["rootId"].(string))
+	jsRootId, err := strconv.Atoi(rootId)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -178,7 +185,8 @@ func (s *DomService) constructDomTree(evalPage map[string]any) (*DOMElementNode,
 	}{}
 
 	for id, nodeData := range jsNodeMap {
-		node, childrenIds := s.parseNode(nodeData.(map[string]any))
+		nodeMapData, _ := nodeData.(map[string]any)
+		node, childrenIds := s.parseNode(nodeMapData)
 		if node == nil {
 			continue
 		}
@@ -239,8 +247,8 @@ func (s *DomService) parseNode(nodeData map[string]any) (DOMBaseNode, []int) {
 	// Process text nodes immediately
 	if nodeData["type"] == "TEXT_NODE" {
 		textNode := &DOMTextNode{
-			Text:      nodeData["text"].(string),
-			IsVisible: nodeData["isVisible"].(bool),
+			Text:      utils.GetDefaultValue(nodeData, "text", ""),
+			IsVisible: utils.GetDefaultValue(nodeData, "isVisible", false),
 			Parent:    nil,
 		}
 		return textNode, []int{}
@@ -250,17 +258,17 @@ func (s *DomService) parseNode(nodeData map[string]any) (DOMBaseNode, []int) {
 
 	var viewportInfo *ViewportInfo
 
-	if nodeData["viewport"] != nil {
+	if viewport, ok := nodeData["viewport"].(map[string]any); ok {
 		viewportInfo = &ViewportInfo{
-			Width:  nodeData["viewport"].(map[string]int)["width"],
-			Height: nodeData["viewport"].(map[string]int)["height"],
+			Width:  int(utils.GetNumberValue(viewport, "width", 0)),
+			Height: int(utils.GetNumberValue(viewport, "height", 0)),
 		}
 	}
 
 	elementNode := &DOMElementNode{
-		TagName:        nodeData["tagName"].(string),
-		Xpath:          nodeData["xpath"].(string),
-		Attributes:     utils.ConvertToStringMap(nodeData["attributes"].(map[string]any)),
+		TagName:        utils.GetDefaultValue(nodeData, "tagName", ""),
+		Xpath:          utils.GetDefaultValue(nodeData, "xpath", ""),
+		Attributes:     utils.ConvertToStringMap(utils.GetDefaultValue[map[string]any](nodeData, "attributes", nil)),
 		Children:       []DOMBaseNode{},
 		IsVisible:      utils.GetDefaultValue(nodeData, "isVisible", false),
 		IsInteractive:  utils.GetDefaultValue(nodeData, "isInteractive", false),
@@ -1,8 +1,15 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // ConvertToStringMap converts a map[string]any to map[string]string.
@@ -32,6 +39,24 @@ func GetDefaultValue[T any](data map[string]any, key string, defaultValue T) T {
 	return defaultValue
 }
 
+// GetNumberValue returns a numeric value of any int or float type as float64,
+// or the default value if the key is not found or not a number
+func GetNumberValue(data map[string]any, key string, defaultValue float64) float64 {
+	switch value := data[key].(type) {
+	case float64:
+		return value
+	case float32:
+		return float64(value)
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case int32:
+		return float64(value)
+	}
+	return defaultValue
+}
+
 func ConvertToSliceOfInt(input any) ([]int, error) {
 	if input == nil {
 		return nil, fmt.Errorf("input cannot be nil")
@@ -64,9 +89,116 @@ func ConvertToSliceOfInt(input any) ([]int, error) {
 	return result, nil
 }
 
+// ConvertToSliceOfString converts a []string or []any to []string, skipping non string elements.
+func ConvertToSliceOfString(input any) []string {
+	switch v := input.(type) {
+	case []string:
+		return v
+	case []any:
+		result := make([]string, 0, len(v))
+		for _, elem := range v {
+			if strValue, ok := elem.(string); ok {
+				result = append(result, strValue)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
 func ConvertToOptional[T any](value any) *T {
 	if value, ok := value.(T); ok {
 		return &value
 	}
 	return nil
 }
+
+// FlattenSensitiveData merges domain scoped secrets into a single placeholder -> value map.
+// domainSensitiveData maps a url pattern to the placeholder -> secret map used on matching pages.
+func FlattenSensitiveData(sensitiveData map[string]string, domainSensitiveData map[string]map[string]string) map[string]string {
+	flattened := make(map[string]string, len(sensitiveData))
+	for placeholder, secret := range sensitiveData {
+		flattened[placeholder] = secret
+	}
+	for _, secrets := range domainSensitiveData {
+		for placeholder, secret := range secrets {
+			flattened[placeholder] = secret
+		}
+	}
+	return flattened
+}
+
+// FilterSensitiveData replaces every sensitive value in text with its <secret>placeholder</secret>.
+// Longer values are replaced first so a value containing another one is not partially leaked.
+func FilterSensitiveData(text string, sensitiveData map[string]string) string {
+	if len(sensitiveData) == 0 || text == "" {
+		return text
+	}
+	keys := make([]string, 0, len(sensitiveData))
+	for key, value := range sensitiveData {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return len(sensitiveData[keys[i]]) > len(sensitiveData[keys[j]])
+	})
+	for _, key := range keys {
+		text = strings.ReplaceAll(text, sensitiveData[key], "<secret>"+key+"</secret>")
+	}
+	return text
+}
+
+// Sleep pauses for d or until ctx is done, in which case the error of ctx is returned
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// MatchUrlWithDomainPattern reports whether rawUrl matches a domain pattern.
+// The host of the url is glob matched against the pattern: "example.com" only matches example.com itself,
+// subdomains need a wildcard ("*.example.com", which also matches example.com). A pattern may be prefixed with
+// a scheme ("https://*.example.com"), patterns without a scheme match any scheme.
+// Used for allowed and blocked domains of the browser as well as for domain filters of actions and sensitive data.
+func MatchUrlWithDomainPattern(rawUrl string, pattern string) bool {
+	parsedURL, err := url.Parse(rawUrl)
+	if err != nil || parsedURL.Hostname() == "" {
+		return false
+	}
+	scheme := strings.ToLower(parsedURL.Scheme)
+	host := strings.ToLower(parsedURL.Hostname())
+
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if patternScheme, rest, ok := strings.Cut(pattern, "://"); ok {
+		if matched, err := path.Match(patternScheme, scheme); err != nil || !matched {
+			return false
+		}
+		pattern = rest
+	}
+	// ignore any path or port in the pattern
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		pattern = pattern[:i]
+	}
+	if h, _, err := net.SplitHostPort(pattern); err == nil {
+		pattern = h
+	}
+	if pattern == "" {
+		return false
+	}
+
+	// *.example.com also matches example.com itself
+	if base, ok := strings.CutPrefix(pattern, "*."); ok && host == base {
+		return true
+	}
+	matched, err := path.Match(pattern, host)
+	return err == nil && matched
+}
@@ -0,0 +1,103 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nerdface-ai/browser-use-go/internals/utils"
+)
+
+func TestFilterSensitiveData(t *testing.T) {
+	sensitiveData := map[string]string{
+		"password": "hunter2",
+		"token":    "hunter2-extended",
+		"empty":    "",
+	}
+	got := utils.FilterSensitiveData("typed hunter2 and hunter2-extended", sensitiveData)
+	expected := "typed <secret>password</secret> and <secret>token</secret>"
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if got := utils.FilterSensitiveData("nothing secret", nil); got != "nothing secret" {
+		t.Errorf("expected text to be unchanged, got %q", got)
+	}
+}
+
+func TestFlattenSensitiveData(t *testing.T) {
+	flattened := utils.FlattenSensitiveData(map[string]string{"x_user": "alice"}, map[string]map[string]string{
+		"https://*.bank.example": {"pin": "1234"},
+		"*.mail.example":         {"mail_password": "secret"},
+	})
+	expected := map[string]string{"x_user": "alice", "pin": "1234", "mail_password": "secret"}
+	if len(flattened) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, flattened)
+	}
+	for key, value := range expected {
+		if flattened[key] != value {
+			t.Errorf("expected %s=%s, got %s", key, value, flattened[key])
+		}
+	}
+}
+
+func TestGetNumberValue(t *testing.T) {
+	data := map[string]any{"int": 3, "float": 1.5, "string": "2"}
+	if got := utils.GetNumberValue(data, "int", 0); got != 3 {
+		t.Errorf("expected 3, got %v", got)
+	}
+	if got := utils.GetNumberValue(data, "float", 0); got != 1.5 {
+		t.Errorf("expected 1.5, got %v", got)
+	}
+	if got := utils.GetNumberValue(data, "string", 7); got != 7 {
+		t.Errorf("expected default for non numeric value, got %v", got)
+	}
+	if got := utils.GetNumberValue(data, "missing", 7); got != 7 {
+		t.Errorf("expected default for missing key, got %v", got)
+	}
+}
+
+func TestSleep(t *testing.T) {
+	if err := utils.Sleep(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := utils.Sleep(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("expected a cancelled sleep to return immediately")
+	}
+}
+
+func TestMatchUrlWithDomainPattern(t *testing.T) {
+	testCases := []struct {
+		url      string
+		pattern  string
+		expected bool
+	}{
+		{"https://example.com/path", "example.com", true},
+		{"https://Example.com", "example.com", true},
+		{"https://www.example.com", "example.com", false},
+		{"https://user.github.io", "github.io", false},
+		{"https://notexample.com", "example.com", false},
+		{"https://a.example.com", "*.example.com", true},
+		{"https://example.com", "*.example.com", true},
+		{"https://www.yahoo.co", "www.yahoo.*", true},
+		{"https://bank.example", "https://bank.example", true},
+		{"http://bank.example", "https://bank.example", false},
+		{"ftp://example.com", "example.com", true},
+		{"ftp://example.com", "https://example.com", false},
+		{"https://example.com:8443", "example.com:8443/path", true},
+		{"https://evil.example/example.com", "example.com", false},
+		{"about:blank", "*", false},
+	}
+	for _, tc := range testCases {
+		if got := utils.MatchUrlWithDomainPattern(tc.url, tc.pattern); got != tc.expected {
+			t.Errorf("MatchUrlWithDomainPattern(%q, %q) = %v, expected %v", tc.url, tc.pattern, got, tc.expected)
+		}
+	}
+}
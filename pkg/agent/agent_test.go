@@ -1,21 +1,38 @@
 package agent
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"net"
 	"os"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/nerdface-ai/browser-use-go/internals/controller"
+	"github.com/nerdface-ai/browser-use-go/internals/dom"
+	"github.com/nerdface-ai/browser-use-go/internals/utils"
 	"github.com/nerdface-ai/browser-use-go/pkg/browser"
 	"github.com/nerdface-ai/browser-use-go/pkg/dotenv"
 
 	_ "github.com/joho/godotenv/autoload"
 
 	"github.com/cloudwego/eino-ext/components/model/openai"
+	"github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/schema"
+	"github.com/playwright-community/playwright-go"
 )
 
 func TestOpenAIChatModel(t *testing.T) {
@@ -221,15 +238,21 @@ func TestMultiAct(t *testing.T) {
 
 	// for test ----------------------------------
 	c := controller.NewController()
-	b := browser.NewBrowser(browser.BrowserConfig{
-		"headless": true,
-	})
+	config := browser.NewBrowserConfig()
+	config.Headless = true
+	b, _ := browser.NewBrowser(config)
 	bc := b.NewContext()
 
-	currentState := bc.GetState(false)
+	currentState, err := bc.GetState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
 	time.Sleep(1 * time.Second)
 
-	session := bc.GetSession()
+	session, err := bc.GetSession()
+	if err != nil {
+		t.Fatal(err)
+	}
 	session.CachedState = currentState
 	// for test ----------------------------------
 
@@ -269,8 +292,814 @@ func TestMultiAct(t *testing.T) {
 	}
 	t.Logf("multiact result: %s", string(jsonResult))
 
-	pageUrl := bc.GetCurrentPage().URL()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageUrl := page.URL()
 	if !strings.HasPrefix(pageUrl, "https://www.naver.com") {
 		t.Errorf("expected page url to be https://www.naver.com, got %s", pageUrl)
 	}
 }
+
+func TestUpdateActionIndices(t *testing.T) {
+	root := &dom.DOMElementNode{TagName: "body", Xpath: "/body", Attributes: map[string]string{}}
+	button := &dom.DOMElementNode{
+		TagName:        "button",
+		Xpath:          "/body/button",
+		Attributes:     map[string]string{"id": "submit"},
+		HighlightIndex: playwright.Int(3),
+		Parent:         root,
+	}
+	root.Children = []dom.DOMBaseNode{button}
+	historicalElement := dom.HistoryTreeProcessor{}.ConvertDomElementToHistoryElement(button)
+
+	// the same element now has a different index on the page
+	button.HighlightIndex = playwright.Int(7)
+	state := &browser.BrowserState{ElementTree: root}
+
+	ag := &Agent{}
+	action := &controller.ActModel{"click_element": map[string]interface{}{"index": float64(3)}}
+	updated := ag.updateActionIndices(historicalElement, action, state)
+	if updated == nil {
+		t.Fatal("expected element to be found in tree")
+	}
+	if index := updated.GetIndex(); index == nil || *index != 7 {
+		t.Errorf("expected index 7, got %v", index)
+	}
+	if index := action.GetIndex(); index == nil || *index != 3 {
+		t.Errorf("expected original action to keep index 3, got %v", index)
+	}
+
+	// element removed from the page
+	root.Children = []dom.DOMBaseNode{}
+	if updated := ag.updateActionIndices(historicalElement, action, state); updated != nil {
+		t.Errorf("expected nil for missing element, got %v", updated)
+	}
+}
+
+func TestCloseWithInjectedContext(t *testing.T) {
+	bc := &browser.BrowserContext{RecordingPaths: []string{"/tmp/recordings/ctx-1.webm"}, HarPath: "/tmp/har/ctx.har"}
+	ag := &Agent{State: NewAgentState(), BrowserContext: bc, InjectedBrowserContext: true}
+	if err := ag.Close(); err != nil {
+		t.Fatal(err)
+	}
+	history := ag.State.History
+	if len(history.RecordingPaths) != 1 || history.HarPath == nil || *history.HarPath != "/tmp/har/ctx.har" {
+		t.Errorf("expected the recording and HAR paths of an injected context, got %v, %v", history.RecordingPaths, history.HarPath)
+	}
+}
+
+func TestHistorySaveWithoutSecrets(t *testing.T) {
+	history := &AgentHistoryList{History: []*AgentHistory{
+		{
+			ModelOutput: &AgentOutput{
+				CurrentState: &AgentBrain{NextGoal: "log in"},
+				Actions: []*controller.ActModel{
+					{"input_text": map[string]interface{}{"index": 1, "text": "hunter2"}},
+				},
+			},
+			Result: []*ActionResult{
+				{ExtractedContent: playwright.String("⌨️  Input hunter2 into index 1"), IncludeInMemory: true},
+				{Error: playwright.String("login failed for pin 1234")},
+			},
+			State:    &BrowserStateHistory{Url: "https://bank.example"},
+			Metadata: &StepMetadata{StepNumber: 1},
+		},
+	}, sensitiveData: utils.FlattenSensitiveData(
+		map[string]string{"password": "hunter2"},
+		map[string]map[string]string{"https://*.bank.example": {"pin": "1234"}},
+	)}
+
+	path := t.TempDir() + "/run.json"
+	if err := history.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	for _, secret := range []string{"hunter2", "1234"} {
+		if strings.Contains(content, secret) {
+			t.Errorf("expected %q to be filtered from the history file, got %s", secret, content)
+		}
+	}
+	loaded, err := LoadHistoryFromFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := loaded.History[0].Result
+	if *result[0].ExtractedContent != "⌨️  Input <secret>password</secret> into index 1" || *result[1].Error != "login failed for pin <secret>pin</secret>" {
+		t.Errorf("expected placeholders in the history file, got %s", content)
+	}
+}
+
+func TestHistorySaveAndLoad(t *testing.T) {
+	history := &AgentHistoryList{History: []*AgentHistory{
+		{
+			ModelOutput: &AgentOutput{
+				CurrentState: &AgentBrain{NextGoal: "open the page"},
+				Actions: []*controller.ActModel{
+					{"go_to_url": map[string]interface{}{"url": "https://example.com"}},
+					{"click_element": map[string]interface{}{"index": 2}},
+				},
+			},
+			Result: []*ActionResult{{ExtractedContent: playwright.String("done"), IncludeInMemory: true}},
+			State: &BrowserStateHistory{
+				Url: "https://example.com",
+				InteractedElement: []*dom.DOMHistoryElement{nil, {
+					TagName:         "a",
+					Xpath:           "/html/body/a",
+					HighlightIndex:  playwright.Int(2),
+					PageCoordinates: &dom.CoordinateSet{Center: dom.Coordinates{X: 10, Y: 20}},
+				}},
+			},
+			Metadata: &StepMetadata{StepNumber: 1, InputTokens: 42},
+		},
+	}, RecordingPaths: []string{"/tmp/recordings/ctx-1.webm"}, HarPath: playwright.String("/tmp/har/ctx.har")}
+
+	path := t.TempDir() + "/history/run.json"
+	if err := history.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	actionModel := controller.NewController().Registry.CreateActionModel(nil, nil)
+	loaded, err := LoadHistoryFromFile(path, actionModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.History) != 1 {
+		t.Fatalf("expected 1 history item, got %d", len(loaded.History))
+	}
+	if len(loaded.RecordingPaths) != 1 || loaded.HarPath == nil || *loaded.HarPath != "/tmp/har/ctx.har" {
+		t.Errorf("expected recording and HAR paths to be restored, got %v, %v", loaded.RecordingPaths, loaded.HarPath)
+	}
+	item := loaded.History[0]
+	if index := item.ModelOutput.Actions[1].GetIndex(); index == nil || *index != 2 {
+		t.Errorf("expected action index 2, got %v", index)
+	}
+	element := item.State.InteractedElement[1]
+	if element == nil || element.PageCoordinates.Center.Y != 20 {
+		t.Errorf("expected interacted element to be restored, got %v", element)
+	}
+	if len(item.Result) != 1 || item.Result[0].ExtractedContent == nil || *item.Result[0].ExtractedContent != "done" {
+		t.Errorf("expected result to be restored, got %v", item.Result)
+	}
+	if item.Metadata.InputTokens != 42 {
+		t.Errorf("expected 42 input tokens, got %d", item.Metadata.InputTokens)
+	}
+
+	// unknown actions are rejected
+	history.History[0].ModelOutput.Actions = []*controller.ActModel{{"fly_away": map[string]interface{}{}}}
+	if err := history.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadHistoryFromFile(path, actionModel); err == nil {
+		t.Error("expected error for unregistered action")
+	}
+}
+
+func TestBuildPlannerMessages(t *testing.T) {
+	ag := &Agent{
+		Settings:       NewAgentSettings(AgentSettingsConfig{"is_planner_reasoning": true}),
+		MessageManager: SampleMessageManager(),
+	}
+	ag.MessageManager.AddMessageWithTokens(&schema.Message{
+		Role: schema.User,
+		MultiContent: []schema.ChatMessagePart{
+			{Type: schema.ChatMessagePartTypeText, Text: "Current url: https://example.com"},
+			{Type: schema.ChatMessagePartTypeImageURL, ImageURL: &schema.ChatMessageImageURL{URL: "data:image/png;base64,AAAA"}},
+		},
+	}, nil, nil)
+
+	messages := ag.buildPlannerMessages("")
+	if messages[0].Role != schema.User || !strings.Contains(messages[0].Content, "planning agent") {
+		t.Errorf("Expected planner prompt as user message for reasoning planner, got %v", messages[0])
+	}
+	if len(messages) != len(ag.MessageManager.GetMessages()) {
+		t.Errorf("Expected agent system prompt to be replaced, got %d messages", len(messages))
+	}
+	last := messages[len(messages)-1]
+	if len(last.MultiContent) != 0 || last.Content != "Current url: https://example.com" {
+		t.Errorf("Expected screenshot to be dropped, got %v", last)
+	}
+	stored := ag.MessageManager.GetMessages()
+	if len(stored[len(stored)-1].MultiContent) != 2 {
+		t.Error("Expected conversation to keep the screenshot")
+	}
+
+	ag.Settings.UseVisionForPlanner = true
+	messages = ag.buildPlannerMessages("")
+	if len(messages[len(messages)-1].MultiContent) != 2 {
+		t.Error("Expected screenshot to be kept with vision for planner")
+	}
+}
+
+func TestRemoveThinkTags(t *testing.T) {
+	cases := map[string]string{
+		"<think>let me see</think>{\"next_steps\": []}": "{\"next_steps\": []}",
+		"reasoning without opening tag</think> plan":    "plan",
+		"plain plan": "plain plan",
+	}
+	for input, expected := range cases {
+		if got := removeThinkTags(input); got != expected {
+			t.Errorf("removeThinkTags(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+// fakeChatModel returns canned responses in order and records its inputs
+type fakeChatModel struct {
+	responses []*schema.Message
+	inputs    [][]*schema.Message
+	tools     []*schema.ToolInfo
+}
+
+func (f *fakeChatModel) Generate(ctx context.Context, input []*schema.Message, _ ...model.Option) (*schema.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.inputs = append(f.inputs, input)
+	if len(f.responses) == 0 {
+		return nil, errors.New("no more responses")
+	}
+	response := f.responses[0]
+	f.responses = f.responses[1:]
+	return response, nil
+}
+
+func (f *fakeChatModel) Stream(_ context.Context, _ []*schema.Message, _ ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	return nil, errors.New("not supported")
+}
+
+func (f *fakeChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	f.tools = tools
+	return f, nil
+}
+
+func toolCallMessage(name string, arguments string) *schema.Message {
+	return &schema.Message{
+		Role:      schema.Assistant,
+		ToolCalls: []schema.ToolCall{{ID: "1", Function: schema.FunctionCall{Name: name, Arguments: arguments}}},
+	}
+}
+
+func TestAskValidator(t *testing.T) {
+	llm := &fakeChatModel{responses: []*schema.Message{
+		toolCallMessage("ValidationResult", `{"is_valid": false, "reason": "the agent searched for dogs"}`),
+	}}
+	ag := &Agent{LLM: llm}
+
+	result, err := ag.askValidator(context.Background(), []*schema.Message{{Role: schema.User, Content: "validate"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.IsValid || result.Reason != "the agent searched for dogs" {
+		t.Errorf("unexpected validation result: %+v", result)
+	}
+	if len(llm.tools) != 1 || llm.tools[0].Name != "ValidationResult" {
+		t.Errorf("expected validator tool to be bound, got %v", llm.tools)
+	}
+}
+
+func TestMultiActContextCancelled(t *testing.T) {
+	ag := &Agent{
+		Controller:     controller.NewController(),
+		BrowserContext: &browser.BrowserContext{Config: browser.NewBrowserContextConfig()},
+		Settings:       &AgentSettings{},
+		State:          NewAgentState(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	actions := []*controller.ActModel{{"wait": map[string]interface{}{"seconds": 60}}}
+	results, err := ag.MultiActContext(ctx, actions, false)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(results) != 1 || results[0].Error == nil || !strings.HasPrefix(*results[0].Error, "Interrupted") {
+		t.Errorf("expected a single interrupted result, got %v", results)
+	}
+
+	// a running wait action is cut short by the deadline
+	deadline, cancelDeadline := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelDeadline()
+	start := time.Now()
+	results, err = ag.MultiActContext(deadline, actions, false)
+	if !errors.Is(err, context.DeadlineExceeded) || len(results) != 1 {
+		t.Errorf("expected an interrupted result after the deadline, got %v %v", results, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected the wait to stop at the deadline, took %s", time.Since(start))
+	}
+
+	// the llm is called with the context of the caller
+	ag.LLM = &fakeChatModel{responses: []*schema.Message{toolCallMessage("AgentOutput", `{}`)}}
+	ag.AgentOutput = &schema.ToolInfo{Name: "AgentOutput"}
+	if _, err := ag.GetNextActionContext(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from the llm, got %v", err)
+	}
+}
+
+func TestPauseResumeStop(t *testing.T) {
+	var mu sync.Mutex
+	var events []AgentStatus
+	ag := &Agent{
+		State: NewAgentState(),
+		RegisterStateChangeCallback: func(event AgentStateEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event.Status)
+		},
+	}
+
+	ag.Pause()
+	ag.Pause()
+	if !ag.IsPaused() {
+		t.Fatal("expected the agent to be paused")
+	}
+	released := make(chan struct{})
+	go func() {
+		ag.waitWhilePaused(nil)
+		close(released)
+	}()
+	select {
+	case <-released:
+		t.Fatal("expected waitWhilePaused to block while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	ag.Resume()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("expected Resume to release waitWhilePaused")
+	}
+
+	ag.Pause()
+	released = make(chan struct{})
+	go func() {
+		ag.waitWhilePaused(nil)
+		close(released)
+	}()
+	ag.Stop()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("expected Stop to release waitWhilePaused")
+	}
+	if !ag.IsStopped() {
+		t.Fatal("expected the agent to be stopped")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []AgentStatus{AgentStatusPaused, AgentStatusRunning, AgentStatusPaused, AgentStatusStopped}
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("expected events %v, got %v", expected, events)
+			break
+		}
+	}
+}
+
+func TestHandleInterrupt(t *testing.T) {
+	ag := &Agent{State: NewAgentState()}
+	ag.handleInterrupt()
+	if !ag.control.stepInterrupted {
+		t.Error("expected the step to be marked as interrupted")
+	}
+	if len(ag.State.LastResult) != 1 || *ag.State.LastResult[0].Error != "The agent was paused" {
+		t.Errorf("expected a neutral pause result, got %v", ag.State.LastResult)
+	}
+
+}
+
+func TestMultiActPaused(t *testing.T) {
+	ag := &Agent{
+		Controller:     controller.NewController(),
+		BrowserContext: &browser.BrowserContext{Config: browser.NewBrowserContextConfig()},
+		Settings:       &AgentSettings{},
+		State:          NewAgentState(),
+	}
+	ag.Pause()
+
+	actions := []*controller.ActModel{{"wait": map[string]interface{}{"seconds": 0}}}
+	results, err := ag.MultiActContext(context.Background(), actions, false)
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("expected errInterrupted, got %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results, got %v", results)
+	}
+}
+
+func TestSignalHandler(t *testing.T) {
+	ag := &Agent{State: NewAgentState()}
+	input, output := io.Pipe()
+	h := newSignalHandler(ag, input)
+	go h.loop()
+	defer h.close()
+
+	waitFor := func(cond func() bool, msg string) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	h.signals <- os.Interrupt
+	waitFor(ag.IsPaused, "expected Ctrl+C to pause the agent")
+	if _, err := output.Write([]byte("\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(func() bool { return !ag.IsPaused() }, "expected Enter to resume the agent")
+
+	h.signals <- os.Interrupt
+	waitFor(ag.IsPaused, "expected Ctrl+C to pause the agent")
+	h.signals <- os.Interrupt
+	waitFor(ag.IsStopped, "expected a second Ctrl+C to stop the agent")
+}
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		kind AgentErrorKind
+	}{
+		{errors.New("error, status code: 429, status: 429 Too Many Requests, message: Rate limit reached"), ErrorKindRateLimit},
+		{fmt.Errorf("failed to create chat completion: %w", errors.New("RESOURCE_EXHAUSTED")), ErrorKindRateLimit},
+		{errors.New("error, status code: 503, status: 503 Service Unavailable, message: overloaded"), ErrorKindNetwork},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, ErrorKindNetwork},
+		{fmt.Errorf("read response: %w", io.ErrUnexpectedEOF), ErrorKindNetwork},
+		{json.Unmarshal([]byte(`{"current_state":`), &AgentOutput{}), ErrorKindParse},
+		{newParseError(errors.New("no tool calls")), ErrorKindParse},
+		{errors.New("error, status code: 400, status: 400 Bad Request, message: invalid request"), ErrorKindUnknown},
+	}
+	for _, tc := range testCases {
+		if kind := classifyError(tc.err).Kind; kind != tc.kind {
+			t.Errorf("expected %s for %q, got %s", tc.kind, tc.err, kind)
+		}
+	}
+}
+
+func TestAllActionsFailed(t *testing.T) {
+	failed := &controller.ActionResult{Error: playwright.String("element with index 3 does not exist")}
+	succeeded := &controller.ActionResult{ExtractedContent: playwright.String("clicked")}
+	cases := []struct {
+		results  []*controller.ActionResult
+		expected bool
+	}{
+		{nil, false},
+		{[]*controller.ActionResult{failed}, true},
+		{[]*controller.ActionResult{failed, failed}, true},
+		{[]*controller.ActionResult{succeeded, failed}, false},
+		{[]*controller.ActionResult{succeeded}, false},
+	}
+	for i, c := range cases {
+		if got := allActionsFailed(c.results); got != c.expected {
+			t.Errorf("case %d: expected %v, got %v", i, c.expected, got)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	testCases := []struct {
+		delay    time.Duration
+		failures int
+		expected time.Duration
+	}{
+		{10 * time.Second, 1, 10 * time.Second},
+		{10 * time.Second, 2, 20 * time.Second},
+		{10 * time.Second, 3, 40 * time.Second},
+		{10 * time.Second, 20, 5 * time.Minute},
+		{0, 3, 0},
+	}
+	for _, tc := range testCases {
+		if backoff := retryBackoff(tc.delay, tc.failures); backoff != tc.expected {
+			t.Errorf("expected %s after %d failures with a delay of %s, got %s", tc.expected, tc.failures, tc.delay, backoff)
+		}
+	}
+}
+
+func TestHandleStepError(t *testing.T) {
+	ag := &Agent{
+		LLM:         &fakeChatModel{responses: []*schema.Message{toolCallMessage("AgentOutput", `{"current_state": {`)}},
+		AgentOutput: &schema.ToolInfo{Name: "AgentOutput"},
+		Settings:    &AgentSettings{MaxFailures: 3, RetryDelay: 0},
+		State:       NewAgentState(),
+	}
+	ctx := context.Background()
+
+	_, err := ag.GetNextActionContext(ctx, nil)
+	var agentErr *AgentError
+	if !errors.As(ag.handleStepError(ctx, err), &agentErr) || agentErr.Kind != ErrorKindParse {
+		t.Fatalf("expected a parse error, got %v", err)
+	}
+	if ag.State.ConsecutiveFailures != 1 {
+		t.Errorf("expected 1 consecutive failure, got %d", ag.State.ConsecutiveFailures)
+	}
+	if len(ag.State.LastResult) != 1 || !ag.State.LastResult[0].IncludeInMemory ||
+		!strings.HasPrefix(*ag.State.LastResult[0].Error, validationErrorMessage) {
+		t.Errorf("expected the parse error to be fed back to the model, got %v", ag.State.LastResult)
+	}
+
+	// rate limits are waited out, the wait ends with the context
+	ag.Settings.RetryDelay = 60
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = ag.handleStepError(cancelled, errors.New("error, status code: 429, status: 429 Too Many Requests"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the backoff to be cancelled, got %v", err)
+	}
+	if ag.State.ConsecutiveFailures != 2 {
+		t.Errorf("expected 2 consecutive failures, got %d", ag.State.ConsecutiveFailures)
+	}
+
+	// no backoff once the run is going to stop
+	err = ag.handleStepError(cancelled, errors.New("error, status code: 429, status: 429 Too Many Requests"))
+	if !errors.As(err, &agentErr) || agentErr.Kind != ErrorKindRateLimit {
+		t.Errorf("expected a rate limit error, got %v", err)
+	}
+}
+
+func TestSetToolCallingMethod(t *testing.T) {
+	auto := Auto
+	expected := map[string]ToolCallingMethod{
+		"openai": FunctionCalling, "anthropic": FunctionCalling, "claude": FunctionCalling, "gemini": FunctionCalling,
+		"ark": FunctionCalling, "qwen": FunctionCalling, "ollama": FunctionCalling, "unknown": FunctionCalling,
+	}
+	for modelName, method := range autoToolCallingMethods {
+		expected[modelName] = method
+	}
+	for modelName, want := range expected {
+		ag := &Agent{ModelName: modelName, Settings: &AgentSettings{ToolCallingMethod: &auto}}
+		got := ag.setToolCallingMethod()
+		if got == nil || *got != want {
+			t.Errorf("%s: expected %s, got %v", modelName, want, got)
+		}
+	}
+	if autoToolCallingMethods["llamacpp"] != Raw {
+		t.Errorf("expected raw tool calling for llamacpp, got %s", autoToolCallingMethods["llamacpp"])
+	}
+
+	jsonMode := JSONMode
+	ag := &Agent{ModelName: "openai", Settings: &AgentSettings{ToolCallingMethod: &jsonMode}}
+	if got := ag.setToolCallingMethod(); got == nil || *got != JSONMode {
+		t.Errorf("expected an explicit method to be kept, got %v", got)
+	}
+}
+
+func TestGetNextActionRaw(t *testing.T) {
+	for _, method := range []ToolCallingMethod{Raw, JSONMode} {
+		testGetNextActionWithoutTools(t, method)
+	}
+
+	method := Raw
+	var agentErr *AgentError
+	ag := &Agent{
+		LLM:               &fakeChatModel{responses: []*schema.Message{{Role: schema.Assistant, Content: "I will search for go"}}},
+		AgentOutput:       &schema.ToolInfo{Name: "AgentOutput", ParamsOneOf: schema.NewParamsOneOfByParams(map[string]*schema.ParameterInfo{})},
+		ToolCallingMethod: &method,
+	}
+	if _, err := ag.GetNextActionContext(context.Background(), nil); !errors.As(err, &agentErr) || agentErr.Kind != ErrorKindParse {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+}
+
+func testGetNextActionWithoutTools(t *testing.T, method ToolCallingMethod) {
+	llm := &fakeChatModel{responses: []*schema.Message{{
+		Role:    schema.Assistant,
+		Content: "```json\n{\"current_state\": {\"next_goal\": \"search\"}, \"actions\": [{\"search_google\": {\"query\": \"go\"}}]}\n```",
+	}}}
+	ag := &Agent{
+		LLM:               llm,
+		AgentOutput:       ToolInfoWithCustomActions(controller.NewController().Registry.CreateActionModel(nil, nil)),
+		ToolCallingMethod: &method,
+	}
+	history := []*schema.Message{
+		{Role: schema.User, Content: "task"},
+		toolCallMessage("AgentOutput", `{"actions": []}`),
+		{Role: schema.Tool, Content: "tool executed", ToolCallID: "1"},
+	}
+
+	output, err := ag.GetNextActionContext(context.Background(), history)
+	if err != nil {
+		t.Fatalf("%s: %s", method, err)
+	}
+	if output.CurrentState.NextGoal != "search" || len(output.Actions) != 1 {
+		t.Errorf("%s: unexpected output %+v", method, output)
+	}
+	if llm.tools != nil {
+		t.Errorf("%s: expected no tools to be bound, got %v", method, llm.tools)
+	}
+	input := llm.inputs[0]
+	if len(input) != len(history)+1 || !strings.Contains(input[len(input)-1].Content, `"current_state"`) {
+		t.Errorf("%s: expected the schema prompt after the history, got %v", method, input)
+	}
+	if jsonMode := strings.Contains(input[len(input)-1].Content, "Your response format is JSON"); jsonMode != (method == JSONMode) {
+		t.Errorf("%s: unexpected json mode instruction in %q", method, input[len(input)-1].Content)
+	}
+	for _, message := range input {
+		if message.Role == schema.Tool || len(message.ToolCalls) > 0 {
+			t.Errorf("%s: expected no tool messages, got %+v", method, message)
+		}
+	}
+}
+
+func TestRepairJSON(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{`{"a": 1,}`, `{"a": 1}`},
+		{`{"a": [1, 2, ], }`, `{"a": [1, 2]}`},
+		{`{"a": "b, }"}`, `{"a": "b, }"}`},
+		{`{"a": {"b": [1, 2`, `{"a": {"b": [1, 2]}}`},
+		{`{"a": "cut off`, `{"a": "cut off"}`},
+		{`{"a": 1, "b`, `{"a": 1, "b": null}`},
+		{`{"a": 1, "b":`, `{"a": 1, "b": null}`},
+		{`{"a": "esc\`, `{"a": "esc"}`},
+	}
+	for _, tc := range testCases {
+		repaired := repairJSON(tc.input)
+		if repaired != tc.expected {
+			t.Errorf("expected %s for %s, got %s", tc.expected, tc.input, repaired)
+		}
+		if !json.Valid([]byte(repaired)) {
+			t.Errorf("repaired JSON %s is not valid", repaired)
+		}
+	}
+}
+
+func TestParseAgentOutput(t *testing.T) {
+	actionModel := controller.NewController().Registry.CreateActionModel(nil, nil)
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{"schema", `{"current_state": {"next_goal": "search"}, "actions": [{"search_google": {"query": "go"}}]}`},
+		{"code block", "```json\n{\"current_state\": {\"next_goal\": \"search\"}, \"actions\": [{\"search_google\": {\"query\": \"go\"}}]}\n```"},
+		{"trailing commas", `{"current_state": {"next_goal": "search",}, "actions": [{"search_google": {"query": "go"},},],}`},
+		{"truncated", `{"current_state": {"next_goal": "search"}, "actions": [{"search_google": {"query": "go"}}`},
+		{"action key", `{"current_state": {"next_goal": "search"}, "action": [{"search_google": {"query": "go"}}]}`},
+		{"single action", `{"current_state": {"next_goal": "search"}, "actions": {"search_google": {"query": "go"}}}`},
+		{"name and params", `{"current_state": {"next_goal": "search"}, "actions": [{"name": "search_google", "params": {"query": "go"}}]}`},
+		{"flat params", `{"next_goal": "search", "actions": [{"action": "search_google", "query": "go"}]}`},
+		{"params as string", `{"current_state": {"next_goal": "search"}, "actions": [{"search_google": "{\"query\": \"go\"}"}]}`},
+		{"list of actions", `[{"search_google": {"query": "go"}}]`},
+	}
+	for _, tc := range testCases {
+		output, err := parseAgentOutput(tc.content, actionModel)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if output.CurrentState == nil {
+			t.Errorf("%s: expected a current state", tc.name)
+			continue
+		}
+		if tc.name != "list of actions" && output.CurrentState.NextGoal != "search" {
+			t.Errorf("%s: expected next goal search, got %+v", tc.name, output.CurrentState)
+		}
+		if len(output.Actions) != 1 {
+			t.Errorf("%s: expected 1 action, got %d", tc.name, len(output.Actions))
+			continue
+		}
+		params, _ := (*output.Actions[0])["search_google"].(map[string]interface{})
+		if params["query"] != "go" {
+			t.Errorf("%s: unexpected action %v", tc.name, *output.Actions[0])
+		}
+	}
+}
+
+func TestParseAgentOutputErrors(t *testing.T) {
+	actionModel := controller.NewController().Registry.CreateActionModel(nil, nil)
+	testCases := []struct {
+		name    string
+		content string
+		invalid []int // indices of the invalid actions
+	}{
+		{"no json", "I will search for go", nil},
+		{"no actions", `{"current_state": {"next_goal": "search"}}`, nil},
+		{"empty actions", `{"current_state": {}, "actions": []}`, nil},
+		{"unknown action", `{"current_state": {}, "actions": [{"search_google": {"query": "go"}}, {"fly_to_moon": {}}]}`, []int{1}},
+		{"invalid params", `{"current_state": {}, "actions": [{"click_element": {"index": "first"}}, {"go_to_url": {}}]}`, []int{0, 1}},
+		{"two names", `{"current_state": {}, "actions": [{"go_back": {}, "wait": {"seconds": 1}}]}`, []int{0}},
+	}
+	for _, tc := range testCases {
+		_, err := parseAgentOutput(tc.content, actionModel)
+		var agentErr *AgentError
+		if !errors.As(err, &agentErr) || agentErr.Kind != ErrorKindParse {
+			t.Errorf("%s: expected a parse error, got %v", tc.name, err)
+			continue
+		}
+		joined, ok := agentErr.Err.(interface{ Unwrap() []error })
+		if tc.invalid == nil {
+			continue
+		}
+		if !ok || len(joined.Unwrap()) != len(tc.invalid) {
+			t.Errorf("%s: expected %d invalid actions, got %v", tc.name, len(tc.invalid), err)
+			continue
+		}
+		for i, actionErr := range joined.Unwrap() {
+			var invalid *InvalidActionError
+			if !errors.As(actionErr, &invalid) || invalid.Index != tc.invalid[i] {
+				t.Errorf("%s: expected action %d to be invalid, got %v", tc.name, tc.invalid[i], actionErr)
+			}
+		}
+		if !strings.Contains(agentErr.Message(), "action") {
+			t.Errorf("%s: expected the message for the model to name the action, got %s", tc.name, agentErr.Message())
+		}
+	}
+}
+
+func TestRerunHistoryContext(t *testing.T) {
+	ag := &Agent{State: NewAgentState()}
+	history := &AgentHistoryList{History: []*AgentHistory{{}, {}}}
+	opts := &RerunHistoryOptions{MaxRetries: 0}
+
+	replayed, err := ag.RerunHistoryContext(context.Background(), history, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed.History) != 2 {
+		t.Errorf("expected 2 skipped steps, got %d", len(replayed.History))
+	}
+	if opts.MaxRetries != 0 {
+		t.Errorf("expected the options of the caller to be left untouched, got %+v", opts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	replayed, err = ag.RerunHistoryContext(ctx, history, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(replayed.History) != 0 {
+		t.Errorf("expected no replayed steps, got %d", len(replayed.History))
+	}
+}
+
+func TestCreateHistoryGif(t *testing.T) {
+	screenshot := func(c color.Color) *string {
+		img := image.NewRGBA(image.Rect(0, 0, 320, 200))
+		draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return playwright.String(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	}
+	history := &AgentHistoryList{History: []*AgentHistory{
+		{
+			ModelOutput: &AgentOutput{CurrentState: &AgentBrain{NextGoal: "open the page"}},
+			State:       &BrowserStateHistory{Url: "https://example.com", Screenshot: screenshot(color.White)},
+		},
+		{
+			// steps without a screenshot are skipped
+			ModelOutput: &AgentOutput{CurrentState: &AgentBrain{NextGoal: "wait"}},
+			State:       &BrowserStateHistory{Url: "https://example.com"},
+		},
+		{
+			ModelOutput: &AgentOutput{CurrentState: &AgentBrain{NextGoal: "click the first link, which has a very long name that needs to be wrapped"}},
+			State:       &BrowserStateHistory{Url: "https://example.com/next", Screenshot: screenshot(color.RGBA{0, 0, 255, 255})},
+		},
+	}}
+
+	path := t.TempDir() + "/gifs/run.gif"
+	if err := CreateHistoryGif("find the next page", history, path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	animation, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// task frame followed by the two screenshots
+	if len(animation.Image) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(animation.Image))
+	}
+	if bounds := animation.Image[0].Bounds(); bounds.Dx() != 320 || bounds.Dy() != 200 {
+		t.Errorf("expected frames of 320x200, got %v", bounds)
+	}
+
+	// nothing to render without screenshots
+	emptyPath := t.TempDir() + "/empty.gif"
+	if err := CreateHistoryGif("task", &AgentHistoryList{History: []*AgentHistory{history.History[1]}}, emptyPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(emptyPath); !os.IsNotExist(err) {
+		t.Errorf("expected no GIF without screenshots")
+	}
+}
@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Status of an agent reported by state change events
+type AgentStatus string
+
+const (
+	AgentStatusRunning AgentStatus = "running"
+	AgentStatusPaused  AgentStatus = "paused"
+	AgentStatusStopped AgentStatus = "stopped"
+)
+
+// Event passed to the state change callback when the agent is paused, resumed or stopped
+type AgentStateEvent struct {
+	AgentId string
+	Status  AgentStatus
+	Step    int
+	Time    time.Time
+}
+
+var errInterrupted = errors.New("interrupted")
+
+// Pause, resume and stop of an agent. Pause and Stop take effect between steps and between the actions of a step.
+type agentControl struct {
+	mu      sync.Mutex
+	resumed chan struct{} // open while the agent is paused, closed by Resume
+	stopped chan struct{} // closed by Stop
+	// set when the last step was paused or stopped before it ran any action, only used by the running step
+	stepInterrupted bool
+}
+
+// Must be called with mu held
+func (c *agentControl) stopChan() chan struct{} {
+	if c.stopped == nil {
+		c.stopped = make(chan struct{})
+	}
+	return c.stopped
+}
+
+// Pause the agent before its next action or step. Safe to call from any goroutine.
+func (ag *Agent) Pause() {
+	c := &ag.control
+	c.mu.Lock()
+	if ag.State.Paused || ag.State.Stopped {
+		c.mu.Unlock()
+		return
+	}
+	ag.State.Paused = true
+	c.resumed = make(chan struct{})
+	c.mu.Unlock()
+
+	log.Info("🔄  Pausing agent")
+	ag.emitStateChange(AgentStatusPaused)
+}
+
+// Resume a paused agent. Safe to call from any goroutine.
+func (ag *Agent) Resume() {
+	c := &ag.control
+	c.mu.Lock()
+	if !ag.State.Paused {
+		c.mu.Unlock()
+		return
+	}
+	ag.State.Paused = false
+	if c.resumed != nil {
+		close(c.resumed)
+		c.resumed = nil
+	}
+	c.mu.Unlock()
+
+	log.Info("▶️  Resuming agent")
+	ag.emitStateChange(AgentStatusRunning)
+}
+
+// Stop the agent before its next action or step, a paused agent is stopped right away. Safe to call from any goroutine.
+func (ag *Agent) Stop() {
+	c := &ag.control
+	c.mu.Lock()
+	if ag.State.Stopped {
+		c.mu.Unlock()
+		return
+	}
+	ag.State.Stopped = true
+	close(c.stopChan())
+	c.mu.Unlock()
+
+	log.Info("⏹️  Stopping agent")
+	ag.emitStateChange(AgentStatusStopped)
+}
+
+func (ag *Agent) IsPaused() bool {
+	ag.control.mu.Lock()
+	defer ag.control.mu.Unlock()
+	return ag.State.Paused
+}
+
+func (ag *Agent) IsStopped() bool {
+	ag.control.mu.Lock()
+	defer ag.control.mu.Unlock()
+	return ag.State.Stopped
+}
+
+// Block while the agent is paused, until it is resumed or stopped or done is closed
+func (ag *Agent) waitWhilePaused(done <-chan struct{}) {
+	c := &ag.control
+	c.mu.Lock()
+	if !ag.State.Paused {
+		c.mu.Unlock()
+		return
+	}
+	// Paused may be set on an injected state
+	if c.resumed == nil {
+		c.resumed = make(chan struct{})
+	}
+	resumed, stopped := c.resumed, c.stopChan()
+	c.mu.Unlock()
+
+	log.Info("⏸️  Agent paused, waiting to be resumed")
+	select {
+	case <-resumed:
+	case <-stopped:
+	case <-done:
+	}
+}
+
+func (ag *Agent) emitStateChange(status AgentStatus) {
+	if ag.RegisterStateChangeCallback == nil {
+		return
+	}
+	ag.RegisterStateChangeCallback(AgentStateEvent{
+		AgentId: ag.State.AgentId,
+		Status:  status,
+		Step:    ag.State.NSteps,
+		Time:    time.Now(),
+	})
+}
+
+// Ctrl+C handling like the python agent: the first Ctrl+C pauses the agent,
+// Enter resumes it and a second Ctrl+C while paused stops it.
+type signalHandler struct {
+	agent   *Agent
+	input   io.Reader
+	signals chan os.Signal
+	done    chan struct{}
+	lines   chan struct{}
+	once    sync.Once
+}
+
+func newSignalHandler(ag *Agent, input io.Reader) *signalHandler {
+	return &signalHandler{
+		agent:   ag,
+		input:   input,
+		signals: make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start handling SIGINT, call close to restore the default behavior
+func (h *signalHandler) start() {
+	signal.Notify(h.signals, os.Interrupt)
+	go h.loop()
+}
+
+func (h *signalHandler) close() {
+	signal.Stop(h.signals)
+	close(h.done)
+}
+
+func (h *signalHandler) loop() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-h.signals:
+			if h.agent.IsPaused() {
+				log.Warn("🛑  Got a second Ctrl+C, stopping the agent")
+				h.agent.Stop()
+				return
+			}
+			h.agent.Pause()
+			log.Warn("⏸️  Got Ctrl+C, paused the agent. Press Enter to resume or Ctrl+C again to stop")
+			h.waitForEnter()
+		case <-h.lines:
+			if h.agent.IsPaused() && !h.agent.IsStopped() {
+				h.agent.Resume()
+			}
+		}
+	}
+}
+
+// Read lines of the input in the background, the reader is only started on the first pause
+func (h *signalHandler) waitForEnter() {
+	h.once.Do(func() {
+		lines := make(chan struct{})
+		h.lines = lines
+		go func() {
+			reader := bufio.NewReader(h.input)
+			for {
+				if _, err := reader.ReadString('\n'); err != nil {
+					return
+				}
+				select {
+				case lines <- struct{}{}:
+				case <-h.done:
+					return
+				}
+			}
+		}()
+	})
+}
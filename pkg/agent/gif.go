@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	gifMaxWidth   = 1024
+	gifFrameDelay = 300 // in 100ths of a second
+	gifMargin     = 20
+)
+
+// Create an animated GIF of the run. The first frame shows the task,
+// followed by one frame per step screenshot captioned with the step's next goal.
+func CreateHistoryGif(task string, history *AgentHistoryList, outputPath string) error {
+	frames := []*image.RGBA{}
+	for i, item := range history.History {
+		if item.State == nil || item.State.Screenshot == nil {
+			continue
+		}
+		screenshot, err := decodeScreenshot(*item.State.Screenshot)
+		if err != nil {
+			log.Warnf("Skipping screenshot of step %d: %s", i+1, err)
+			continue
+		}
+		goal := ""
+		if item.ModelOutput != nil && item.ModelOutput.CurrentState != nil {
+			goal = item.ModelOutput.CurrentState.NextGoal
+		}
+		if goal != "" {
+			drawCaption(screenshot, fmt.Sprintf("%d. %s", i+1, goal))
+		}
+		frames = append(frames, screenshot)
+	}
+	if len(frames) == 0 {
+		log.Warn("No screenshots found in history, skipping GIF")
+		return nil
+	}
+	frames = append([]*image.RGBA{taskFrame(task, frames[0].Bounds())}, frames...)
+
+	animation := &gif.GIF{}
+	for _, frame := range frames {
+		paletted := image.NewPaletted(frame.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, frame.Bounds(), frame, image.Point{})
+		animation.Image = append(animation.Image, paletted)
+		animation.Delay = append(animation.Delay, gifFrameDelay)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := gif.EncodeAll(f, animation); err != nil {
+		return err
+	}
+	log.Infof("🎬  Created GIF at %s", outputPath)
+	return nil
+}
+
+// Decode a base64 encoded screenshot, scaled down to at most gifMaxWidth pixels wide
+func decodeScreenshot(screenshot string) (*image.RGBA, error) {
+	data, err := base64.StdEncoding.DecodeString(screenshot)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > gifMaxWidth {
+		height = height * gifMaxWidth / width
+		width = gifMaxWidth
+	}
+	// nearest neighbour scaling, good enough for a summary
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			scaled.Set(x, y, img.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height))
+		}
+	}
+	return scaled, nil
+}
+
+// A black frame with the task text
+func taskFrame(task string, bounds image.Rectangle) *image.RGBA {
+	frame := image.NewRGBA(bounds)
+	draw.Draw(frame, bounds, image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	scale := 3
+	lines := wrapText(task, (bounds.Dx()-2*gifMargin)/(glyphAdvance*scale))
+	lineHeight := (glyphHeight + 4) * scale
+	y := (bounds.Dy() - len(lines)*lineHeight) / 2
+	for _, line := range lines {
+		x := (bounds.Dx() - len(line)*glyphAdvance*scale) / 2
+		drawText(frame, line, x, max(y, gifMargin), scale, color.White)
+		y += lineHeight
+	}
+	return frame
+}
+
+// Draw text in a translucent band at the bottom of the frame
+func drawCaption(frame *image.RGBA, text string) {
+	bounds := frame.Bounds()
+	scale := 2
+	lines := wrapText(text, (bounds.Dx()-2*gifMargin)/(glyphAdvance*scale))
+	lineHeight := (glyphHeight + 4) * scale
+	bandHeight := len(lines)*lineHeight + gifMargin
+	band := image.Rect(bounds.Min.X, bounds.Max.Y-bandHeight, bounds.Max.X, bounds.Max.Y)
+	draw.Draw(frame, band, image.NewUniform(color.RGBA{0, 0, 0, 180}), image.Point{}, draw.Over)
+
+	y := band.Min.Y + gifMargin/2
+	for _, line := range lines {
+		drawText(frame, line, bounds.Min.X+gifMargin, y, scale, color.White)
+		y += lineHeight
+	}
+}
+
+// Split text into lines of at most width characters, breaking at spaces where possible
+func wrapText(text string, width int) []string {
+	width = max(width, 1)
+	lines := []string{}
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			for len(word) > width {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				lines = append(lines, word[:width])
+				word = word[width:]
+			}
+			switch {
+			case line == "":
+				line = word
+			case len(line)+1+len(word) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Draw text with the built-in bitmap font, (x, y) is the top left corner
+func drawText(img *image.RGBA, text string, x int, y int, scale int, c color.Color) {
+	for _, r := range text {
+		glyph := glyphFor(r)
+		for col := 0; col < glyphWidth; col++ {
+			bits := glyph[col]
+			for row := 0; row < glyphHeight; row++ {
+				if bits&(1<<row) == 0 {
+					continue
+				}
+				dot := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+				draw.Draw(img, dot, image.NewUniform(c), image.Point{}, draw.Src)
+			}
+		}
+		x += glyphAdvance * scale
+	}
+}
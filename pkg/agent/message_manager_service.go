@@ -6,6 +6,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -19,13 +20,14 @@ import (
 )
 
 type MessageManagerSettings struct {
-	MaxInputTokens              int               `json:"max_input_tokens"`
-	EstimatedCharactersPerToken int               `json:"estimated_characters_per_token"`
-	ImageTokens                 int               `json:"image_tokens"`
-	IncludeAttributes           []string          `json:"include_attributes"`
-	MessageContext              *string           `json:"message_context,omitempty"`
-	SensitiveData               map[string]string `json:"sensitive_data"`
-	AvailableFilePaths          []string          `json:"available_file_paths"`
+	MaxInputTokens              int                          `json:"max_input_tokens"`
+	EstimatedCharactersPerToken int                          `json:"estimated_characters_per_token"`
+	ImageTokens                 int                          `json:"image_tokens"`
+	IncludeAttributes           []string                     `json:"include_attributes"`
+	MessageContext              *string                      `json:"message_context,omitempty"`
+	SensitiveData               map[string]string            `json:"sensitive_data"`
+	DomainSensitiveData         map[string]map[string]string `json:"domain_sensitive_data"`
+	AvailableFilePaths          []string                     `json:"available_file_paths"`
 }
 
 type MessageManagerConfig map[string]interface{}
@@ -38,6 +40,7 @@ func NewMessageManagerSettings(config MessageManagerConfig) *MessageManagerSetti
 		IncludeAttributes:           utils.GetDefaultValue[[]string](config, "include_attributes", []string{}),
 		MessageContext:              utils.GetDefaultValue[*string](config, "message_context", nil),
 		SensitiveData:               utils.GetDefaultValue[map[string]string](config, "sensitive_data", nil),
+		DomainSensitiveData:         utils.GetDefaultValue[map[string]map[string]string](config, "domain_sensitive_data", nil),
 		AvailableFilePaths:          utils.GetDefaultValue[[]string](config, "available_file_paths", nil),
 	}
 }
@@ -113,12 +116,17 @@ func (m *MessageManager) initMessages() {
 	}
 	m.AddMessageWithTokens(taskMessage, nil, &initStr)
 
-	if m.Settings.SensitiveData != nil {
-		data := m.Settings.SensitiveData
-		keys := make([]string, 0, len(data))
-		for k := range data {
+	if m.Settings.SensitiveData != nil || m.Settings.DomainSensitiveData != nil {
+		keys := make([]string, 0, len(m.Settings.SensitiveData))
+		for k := range m.Settings.SensitiveData {
 			keys = append(keys, k)
 		}
+		for pattern, secrets := range m.Settings.DomainSensitiveData {
+			for placeholder := range secrets {
+				keys = append(keys, fmt.Sprintf("%s (only on %s)", placeholder, pattern))
+			}
+		}
+		sort.Strings(keys)
 		info := fmt.Sprintf("Here are placeholders for sensitive data: %s", strings.Join(keys, ", "))
 		info += "To use them, write <secret>the placeholder name</secret>"
 		infoMessage := &schema.Message{
@@ -157,10 +165,8 @@ func (m *MessageManager) initMessages() {
 			},
 		},
 	}
-	argsBytes, err := json.Marshal(args)
-	if err != nil {
-		panic(err)
-	}
+	// the example only holds strings and ints, so it always marshals
+	argsBytes, _ := json.Marshal(args)
 
 	exampleToolCall := &schema.Message{
 		Role:    schema.Assistant,
@@ -307,10 +313,10 @@ func (m *MessageManager) AddMessageWithTokens(
 		position: None for last, -1 for second last, etc.
 	*/
 
-	// TODO(HIGH): filter out sensitive data from the message
-	// if m.Settings.SensitiveData != nil {
-	// 	message = filterSensitiveData(message)
-	// }
+	// filter out sensitive data from the message
+	if len(m.Settings.SensitiveData) > 0 || len(m.Settings.DomainSensitiveData) > 0 {
+		message = filterSensitiveData(message, utils.FlattenSensitiveData(m.Settings.SensitiveData, m.Settings.DomainSensitiveData))
+	}
 
 	tokenCount := m.countTokens(message)
 	metadata := &MessageMetadata{
@@ -337,7 +343,7 @@ func (m *MessageManager) countTokens(message *schema.Message) int {
 		if message.ToolCalls != nil {
 			argsBytes, err := json.Marshal(message.ToolCalls)
 			if err != nil {
-				panic(err)
+				log.Warnf("Failed to count tokens of tool calls: %s", err)
 			}
 			msg += string(argsBytes)
 		}
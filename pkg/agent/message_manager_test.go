@@ -8,6 +8,7 @@ import (
 	"github.com/nerdface-ai/browser-use-go/pkg/browser"
 
 	"github.com/cloudwego/eino/schema"
+	"github.com/playwright-community/playwright-go"
 )
 
 func SampleMessageManager() *MessageManager {
@@ -83,3 +84,97 @@ func TestAddStateMessage(t *testing.T) {
 		t.Errorf("Expected state message to include %s, got %s", testUrl, messages[2].Content)
 	}
 }
+
+func TestAddPlan(t *testing.T) {
+	messageManager := SampleMessageManager()
+	messageManager.AddMessageWithTokens(&schema.Message{Role: schema.User, Content: "state"}, nil, nil)
+
+	plan := "next: click the button"
+	messageManager.AddPlan(&plan, playwright.Int(-1))
+
+	messages := messageManager.GetMessages()
+	if messages[len(messages)-2].Content != plan {
+		t.Errorf("Expected plan before last message, got %s", messages[len(messages)-2].Content)
+	}
+	if messages[len(messages)-1].Content != "state" {
+		t.Errorf("Expected state message to stay last, got %s", messages[len(messages)-1].Content)
+	}
+}
+
+func TestFilterSensitiveDataInMessages(t *testing.T) {
+	messageManager := SampleMessageManager()
+	messageManager.Settings.SensitiveData = map[string]string{"x_password": "hunter2"}
+
+	messageManager.AddMessageWithTokens(&schema.Message{
+		Role:         schema.User,
+		Content:      "password is hunter2",
+		MultiContent: []schema.ChatMessagePart{{Type: schema.ChatMessagePartTypeText, Text: "typed hunter2"}},
+	}, nil, nil)
+	messageManager.AddMessageWithTokens(&schema.Message{
+		Role:      schema.Assistant,
+		ToolCalls: []schema.ToolCall{{Function: schema.FunctionCall{Name: "AgentOutput", Arguments: `{"text":"hunter2"}`}}},
+	}, nil, nil)
+
+	messages := messageManager.GetMessages()
+	user := messages[len(messages)-2]
+	if user.Content != "password is <secret>x_password</secret>" {
+		t.Errorf("expected content to be filtered, got %s", user.Content)
+	}
+	if user.MultiContent[0].Text != "typed <secret>x_password</secret>" {
+		t.Errorf("expected multi content to be filtered, got %s", user.MultiContent[0].Text)
+	}
+	assistant := messages[len(messages)-1]
+	if strings.Contains(assistant.ToolCalls[0].Function.Arguments, "hunter2") {
+		t.Errorf("expected tool call arguments to be filtered, got %s", assistant.ToolCalls[0].Function.Arguments)
+	}
+}
+
+func TestExtractJSONFromModelOutput(t *testing.T) {
+	testCases := []struct {
+		content  string
+		expected string
+	}{
+		{`{"a": 1}`, `{"a": 1}`},
+		{"```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"Here is my answer:\n```\n{\"a\": {\"b\": 2}}\n```\nDone.", `{"a": {"b": 2}}`},
+		{"<think>I should answer with {\"x\": 0}</think>\nSure: {\"a\": 1} hope this helps", `{"a": 1}`},
+	}
+	for _, tc := range testCases {
+		extracted, err := extractJSONFromModelOutput(tc.content)
+		if err != nil {
+			t.Errorf("failed to extract JSON from %q: %s", tc.content, err)
+			continue
+		}
+		if extracted != tc.expected {
+			t.Errorf("expected %s, got %s", tc.expected, extracted)
+		}
+	}
+
+	if _, err := extractJSONFromModelOutput("I can't do that"); err == nil {
+		t.Error("expected an error for output without JSON")
+	}
+}
+
+func TestConvertInputMessages(t *testing.T) {
+	messages := []*schema.Message{
+		{Role: schema.System, Content: "system"},
+		{Role: schema.Assistant, ToolCalls: []schema.ToolCall{{ID: "1", Function: schema.FunctionCall{Name: "AgentOutput", Arguments: `{"actions": []}`}}}},
+		{Role: schema.Tool, Content: "tool executed", ToolCallID: "1"},
+	}
+	converted := convertInputMessages(messages)
+	if len(converted) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(converted))
+	}
+	if converted[0] != messages[0] {
+		t.Error("expected plain messages to be kept")
+	}
+	if converted[1].Role != schema.Assistant || len(converted[1].ToolCalls) != 0 || converted[1].Content != `{"actions": []}` {
+		t.Errorf("expected the tool call as content, got %+v", converted[1])
+	}
+	if converted[2].Role != schema.User || converted[2].Content != "tool executed" {
+		t.Errorf("expected the tool result as user message, got %+v", converted[2])
+	}
+	if len(messages[1].ToolCalls) != 1 {
+		t.Error("expected the input messages to be left untouched")
+	}
+}
@@ -1 +1,124 @@
 package agent
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/nerdface-ai/browser-use-go/internals/utils"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+var (
+	thinkTags     = regexp.MustCompile(`(?s)<think>.*?</think>`)
+	strayCloseTag = regexp.MustCompile(`(?s)^.*?</think>`)
+	codeBlock     = regexp.MustCompile("(?s)```[a-zA-Z]*\\s*(.*?)```")
+)
+
+// Extract the JSON object of a model response, models without function calling
+// like to wrap it in a code block or add some text around it
+func extractJSONFromModelOutput(content string) (string, error) {
+	content = removeThinkTags(content)
+	if match := codeBlock.FindStringSubmatch(content); match != nil {
+		content = match[1]
+	}
+	start := strings.IndexAny(content, "{[")
+	if start < 0 {
+		return "", errors.New("no JSON object found in the model output")
+	}
+	// find the end of the value, text after it is dropped and output cut off by the token limit is kept for repairJSON
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return content[start : i+1], nil
+			}
+		}
+	}
+	return strings.TrimSpace(content[start:]), nil
+}
+
+// Replace tool calls and tool results with plain messages for models without function calling
+func convertInputMessages(messages []*schema.Message) []*schema.Message {
+	converted := make([]*schema.Message, 0, len(messages))
+	for _, message := range messages {
+		switch {
+		case message.Role == schema.Tool:
+			converted = append(converted, &schema.Message{Role: schema.User, Content: message.Content})
+		case message.Role == schema.Assistant && len(message.ToolCalls) > 0:
+			var parts []string
+			if message.Content != "" {
+				parts = append(parts, message.Content)
+			}
+			for _, toolCall := range message.ToolCalls {
+				parts = append(parts, toolCall.Function.Arguments)
+			}
+			converted = append(converted, &schema.Message{Role: schema.Assistant, Content: strings.Join(parts, "\n")})
+		default:
+			converted = append(converted, message)
+		}
+	}
+	return converted
+}
+
+// Remove <think> reasoning blocks from the output of reasoning models
+func removeThinkTags(text string) string {
+	text = thinkTags.ReplaceAllString(text, "")
+	// the opening tag may be omitted by the model
+	text = strayCloseTag.ReplaceAllString(text, "")
+	return strings.TrimSpace(text)
+}
+
+// Return a copy of the message with all images dropped
+func textOnlyMessage(message *schema.Message) *schema.Message {
+	if len(message.MultiContent) == 0 {
+		return message
+	}
+	text := message.Content
+	for _, part := range message.MultiContent {
+		if part.Type == schema.ChatMessagePartTypeText {
+			text += part.Text
+		}
+	}
+	copied := *message
+	copied.Content = text
+	copied.MultiContent = nil
+	return &copied
+}
+
+// Return a copy of the message with sensitive values replaced by their placeholders
+func filterSensitiveData(message *schema.Message, sensitiveData map[string]string) *schema.Message {
+	copied := *message
+	copied.Content = utils.FilterSensitiveData(message.Content, sensitiveData)
+	if len(message.MultiContent) > 0 {
+		copied.MultiContent = make([]schema.ChatMessagePart, len(message.MultiContent))
+		for i, part := range message.MultiContent {
+			if part.Type == schema.ChatMessagePartTypeText {
+				part.Text = utils.FilterSensitiveData(part.Text, sensitiveData)
+			}
+			copied.MultiContent[i] = part
+		}
+	}
+	if len(message.ToolCalls) > 0 {
+		copied.ToolCalls = make([]schema.ToolCall, len(message.ToolCalls))
+		for i, toolCall := range message.ToolCalls {
+			toolCall.Function.Arguments = utils.FilterSensitiveData(toolCall.Function.Arguments, sensitiveData)
+			copied.ToolCalls[i] = toolCall
+		}
+	}
+	return &copied
+}
@@ -28,7 +28,7 @@ func (m *MessageHistory) AddMessage(message *schema.Message, metadata *MessageMe
 	} else {
 		idx := *position
 		if idx < 0 {
-			idx = len(m.Messages) - 1 + idx
+			idx = max(len(m.Messages)+idx, 0)
 		}
 		m.Messages = slices.Insert(m.Messages, idx, ManagedMessage{Message: message, Metadata: metadata})
 	}
@@ -0,0 +1,273 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nerdface-ai/browser-use-go/internals/controller"
+
+	"github.com/charmbracelet/log"
+)
+
+// Keys under which models put the action list or the current state when they do not follow the schema exactly
+var (
+	actionListKeys   = []string{"actions", "action", "next_actions", "next_action", "tool_calls"}
+	currentStateKeys = []string{"current_state", "currentState", "state", "brain"}
+	actionNameKeys   = []string{"name", "action"}
+	actionParamsKeys = []string{"params", "parameters", "arguments", "args", "input"}
+)
+
+// Invalid action in the output of the model, Index is the position of the action in the action list
+type InvalidActionError struct {
+	Index  int
+	Action string
+	Err    error
+}
+
+func (e *InvalidActionError) Error() string {
+	return fmt.Sprintf("action %d: %s", e.Index+1, e.Err)
+}
+
+func (e *InvalidActionError) Unwrap() error {
+	return e.Err
+}
+
+// Parse the output of the model into an AgentOutput.
+// Code blocks, trailing commas and output cut off at the token limit are repaired and the action list is accepted
+// in the shapes models commonly use instead of the schema. Each action is validated against the parameter schema
+// of actionModel, if given. Errors are parse errors which list every problem, so the model can fix them in the next step.
+func parseAgentOutput(content string, actionModel *controller.ActionModel) (*AgentOutput, error) {
+	jsonStr, err := extractJSONFromModelOutput(content)
+	if err != nil {
+		return nil, newParseError(err)
+	}
+	var raw interface{}
+	if err := json.Unmarshal([]byte(jsonStr), &raw); err != nil {
+		repaired := repairJSON(jsonStr)
+		if json.Unmarshal([]byte(repaired), &raw) != nil {
+			return nil, newParseError(fmt.Errorf("invalid JSON: %w", err))
+		}
+		log.Debugf("Repaired model output: %s", repaired)
+	}
+
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		list, isList := raw.([]interface{})
+		if !isList {
+			return nil, newParseError(errors.New("the output must be a JSON object with current_state and actions"))
+		}
+		obj = map[string]interface{}{"actions": list}
+	}
+
+	output := &AgentOutput{CurrentState: parseCurrentState(obj)}
+	items, err := actionList(obj)
+	if err != nil {
+		return nil, newParseError(err)
+	}
+
+	var errs []error
+	for i, item := range items {
+		action, err := parseAction(item)
+		if err == nil && actionModel != nil {
+			err = actionModel.ValidateAction(action)
+		}
+		if err != nil {
+			actionErr := &InvalidActionError{Index: i, Err: err}
+			if action != nil {
+				for name := range *action {
+					actionErr.Action = name
+				}
+			}
+			errs = append(errs, actionErr)
+			continue
+		}
+		output.Actions = append(output.Actions, action)
+	}
+	if len(errs) > 0 {
+		return nil, newParseError(errors.Join(errs...))
+	}
+	return output, nil
+}
+
+// Current state of the output, a missing state is left empty instead of failing the step
+func parseCurrentState(obj map[string]interface{}) *AgentBrain {
+	brain := &AgentBrain{}
+	state := firstValue(obj, currentStateKeys)
+	if state == nil {
+		// the fields of the state may be written next to the actions
+		state = obj
+	}
+	if s, ok := state.(string); ok {
+		brain.NextGoal = s
+		return brain
+	}
+	b, err := json.Marshal(state)
+	if err != nil || json.Unmarshal(b, brain) != nil {
+		log.Warnf("Failed to parse current state of the model output: %v", state)
+	}
+	return brain
+}
+
+// Items of the action list, a single action is accepted as a list of one
+func actionList(obj map[string]interface{}) ([]interface{}, error) {
+	list := firstValue(obj, actionListKeys)
+	if list == nil {
+		// the output is a single action, e.g. {"click_element": {"index": 3}}
+		if len(obj) == 1 && firstValue(obj, currentStateKeys) == nil {
+			return []interface{}{obj}, nil
+		}
+		return nil, errors.New("the output has no actions, add at least one action to the actions list")
+	}
+	if s, ok := list.(string); ok {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+			return nil, fmt.Errorf("actions must be a list of objects, got a string: %w", err)
+		}
+		list = decoded
+	}
+	switch v := list.(type) {
+	case []interface{}:
+		if len(v) == 0 {
+			return nil, errors.New("the actions list is empty, add at least one action")
+		}
+		return v, nil
+	case map[string]interface{}:
+		return []interface{}{v}, nil
+	}
+	return nil, fmt.Errorf("actions must be a list of objects, got %T", list)
+}
+
+// Parse a single action, {"name": "click_element", "params": {...}} is accepted next to {"click_element": {...}}
+func parseAction(item interface{}) (*controller.ActModel, error) {
+	if s, ok := item.(string); ok {
+		var decoded interface{}
+		if json.Unmarshal([]byte(s), &decoded) == nil {
+			item = decoded
+		}
+	}
+	obj, ok := item.(map[string]interface{})
+	if !ok {
+		return nil, errors.New(`an action must be an object like {"action_name": {"param": "value"}}`)
+	}
+
+	for _, key := range actionNameKeys {
+		name, ok := obj[key].(string)
+		if !ok || name == "" {
+			continue
+		}
+		params := firstValue(obj, actionParamsKeys)
+		if params == nil {
+			// the parameters may be written next to the name
+			flat := map[string]interface{}{}
+			for k, v := range obj {
+				if k != key {
+					flat[k] = v
+				}
+			}
+			params = flat
+		}
+		obj = map[string]interface{}{name: params}
+		break
+	}
+
+	if len(obj) != 1 {
+		return nil, fmt.Errorf("an action must contain exactly one action name, got %d", len(obj))
+	}
+	action := controller.ActModel{}
+	for name, params := range obj {
+		if s, ok := params.(string); ok {
+			var decoded map[string]interface{}
+			if json.Unmarshal([]byte(s), &decoded) == nil {
+				params = decoded
+			}
+		}
+		if params == nil {
+			params = map[string]interface{}{}
+		}
+		action[name] = params
+	}
+	return &action, nil
+}
+
+func firstValue(obj map[string]interface{}, keys []string) interface{} {
+	for _, key := range keys {
+		if v, ok := obj[key]; ok && v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// Fix the most common defects of JSON written by models: trailing commas and output cut off at the token limit.
+// Open strings, objects and lists are closed, a key without value gets null.
+func repairJSON(s string) string {
+	out := make([]byte, 0, len(s)+8)
+	var closers []byte
+	inString, escaped := false, false
+	// last character outside of strings, tells whether a cut off string is a key
+	var lastToken byte
+	var tokenBeforeString byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			out = append(out, c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+				lastToken = '"'
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+			tokenBeforeString = lastToken
+		case '{':
+			closers = append(closers, '}')
+		case '[':
+			closers = append(closers, ']')
+		case '}', ']':
+			out = trimTrailingComma(out)
+			if len(closers) > 0 {
+				closers = closers[:len(closers)-1]
+			}
+		}
+		out = append(out, c)
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			lastToken = c
+		}
+	}
+
+	if inString {
+		if escaped {
+			out = out[:len(out)-1]
+		}
+		out = append(out, '"')
+		if len(closers) > 0 && closers[len(closers)-1] == '}' && (tokenBeforeString == '{' || tokenBeforeString == ',') {
+			out = append(out, ": null"...)
+		}
+	} else {
+		out = trimTrailingComma(out)
+		if trimmed := strings.TrimRight(string(out), " \t\r\n"); strings.HasSuffix(trimmed, ":") {
+			out = append([]byte(trimmed), " null"...)
+		}
+	}
+	for i := len(closers) - 1; i >= 0; i-- {
+		out = append(out, closers[i])
+	}
+	return string(out)
+}
+
+func trimTrailingComma(b []byte) []byte {
+	trimmed := strings.TrimRight(string(b), " \t\r\n")
+	if strings.HasSuffix(trimmed, ",") {
+		return []byte(trimmed[:len(trimmed)-1])
+	}
+	return b
+}
@@ -1,7 +1,8 @@
 package agent
 
 import (
-	"embed"
+	_ "embed"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -13,7 +14,7 @@ import (
 )
 
 //go:embed system_prompt.md
-var template embed.FS
+var systemPromptTemplate string
 
 type SystemPrompt struct {
 	SystemMessage            *schema.Message
@@ -51,12 +52,74 @@ func NewSystemPrompt(
 }
 
 func (sp *SystemPrompt) loadPromptTemplate() string {
-	// Load the prompt template from the markdown file
-	data, err := template.ReadFile("system_prompt.md")
+	// Load the prompt template from the markdown file, embedded at build time
+	return systemPromptTemplate
+}
+
+// Prompt for models without function calling, the AgentOutput tool is described by its JSON schema.
+// jsonMode is set when the response format of the model is restricted to JSON.
+func agentOutputSchemaPrompt(agentOutput *schema.ToolInfo, jsonMode bool) (string, error) {
+	if agentOutput == nil || agentOutput.ParamsOneOf == nil {
+		return "", fmt.Errorf("agent output has no schema")
+	}
+	params, err := agentOutput.ParamsOneOf.ToOpenAPIV3()
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(params)
 	if err != nil {
-		panic(err)
+		return "", err
+	}
+	prompt := "Respond with a single JSON object which matches the following JSON schema. "
+	if jsonMode {
+		prompt += "Your response format is JSON: reply with the bare JSON object only, without code blocks, comments or any other text.\n"
+	} else {
+		prompt += "Do not call any tools and do not write anything outside of the JSON object.\n"
+	}
+	prompt += fmt.Sprintf("```json\n%s\n```", b)
+	return prompt, nil
+}
+
+type PlannerPrompt struct {
+	AvailableActions string
+}
+
+func NewPlannerPrompt(availableActions string) *PlannerPrompt {
+	return &PlannerPrompt{AvailableActions: availableActions}
+}
+
+func (pp *PlannerPrompt) GetSystemMessage(isPlannerReasoning bool) *schema.Message {
+	prompt := `You are a planning agent that helps break down tasks into smaller steps and reason about the current state.
+Your role is to:
+1. Analyze the current state and history
+2. Evaluate progress towards the ultimate goal
+3. Identify potential challenges or roadblocks
+4. Suggest the next high-level steps to take
+
+Inside your messages, there will be AI messages from different agents with different formats.
+
+Your output format should be always a JSON object with the following fields:
+{
+    "state_analysis": "Brief analysis of the current state and what has been done so far",
+    "progress_evaluation": "Evaluation of progress towards the ultimate goal (as percentage and description)",
+    "challenges": "List any potential challenges or roadblocks",
+    "next_steps": "List 2-3 concrete next steps to take",
+    "reasoning": "Explain your reasoning for the suggested next steps"
+}
+
+Ignore the other AI messages output structures.
+
+Keep your responses concise and focused on actionable insights.`
+
+	if pp.AvailableActions != "" {
+		prompt += fmt.Sprintf("\n\nThe agent can use the following actions:\n%s", pp.AvailableActions)
+	}
+
+	// reasoning models often don't support system messages
+	if isPlannerReasoning {
+		return &schema.Message{Role: schema.User, Content: prompt}
 	}
-	return string(data)
+	return &schema.Message{Role: schema.System, Content: prompt}
 }
 
 type AgentMessagePrompt struct {
@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/nerdface-ai/browser-use-go/internals/controller"
 	"github.com/nerdface-ai/browser-use-go/internals/dom"
+	"github.com/nerdface-ai/browser-use-go/internals/utils"
 	"github.com/nerdface-ai/browser-use-go/pkg/browser"
 
 	"github.com/charmbracelet/log"
@@ -25,6 +28,7 @@ type Agent struct {
 	LLM                    model.ToolCallingChatModel
 	Controller             *controller.Controller
 	SensitiveData          map[string]string
+	DomainSensitiveData    map[string]map[string]string
 	Settings               *AgentSettings
 	State                  *AgentState
 	InjectedBrowser        bool
@@ -41,6 +45,7 @@ type Agent struct {
 	RegisterNewStepCallback                       func(state *browser.BrowserState, output *AgentOutput, n int)
 	RegisterDoneCallback                          func(history *AgentHistoryList)
 	RegisterExternalAgentStatusRaiseErrorCallback func() bool
+	RegisterStateChangeCallback                   func(event AgentStateEvent)
 
 	ToolCallingMethod *ToolCallingMethod `json:"tool_calling_method,omitempty"`
 
@@ -53,6 +58,8 @@ type Agent struct {
 
 	UnfilteredActions string
 	InitialActions    []*controller.ActModel
+
+	control agentControl
 }
 
 type AgentOption func(*AgentOptions)
@@ -82,6 +89,13 @@ func WithSensitiveData(data map[string]string) AgentOption {
 		o.sensitiveData = data
 	}
 }
+
+// Secrets which are only used on pages matching the url pattern key e.g. "https://*.bank.example"
+func WithDomainSensitiveData(data map[string]map[string]string) AgentOption {
+	return func(o *AgentOptions) {
+		o.domainSensitiveData = data
+	}
+}
 func WithInitialActions(actions []interface{}) AgentOption {
 	return func(o *AgentOptions) {
 		o.initialActions = actions
@@ -102,6 +116,11 @@ func WithRegisterExternalAgentStatusRaiseErrorCallback(callback func() bool) Age
 		o.registerExternalAgentStatusRaiseErrorCallback = callback
 	}
 }
+func WithRegisterStateChangeCallback(callback func(event AgentStateEvent)) AgentOption {
+	return func(o *AgentOptions) {
+		o.registerStateChangeCallback = callback
+	}
+}
 func WithInjectedAgentState(state *AgentState) AgentOption {
 	return func(o *AgentOptions) {
 		o.injectedAgentState = state
@@ -119,13 +138,15 @@ type AgentOptions struct {
 	controller     *controller.Controller
 
 	// Initial agent run parameters
-	sensitiveData  map[string]string
-	initialActions []interface{}
+	sensitiveData       map[string]string
+	domainSensitiveData map[string]map[string]string
+	initialActions      []interface{}
 
 	// Cloud Callbacks
 	registerNewStepCallback                       func(state *browser.BrowserState, output *AgentOutput, n int)
 	registerDoneCallback                          func(history *AgentHistoryList)
 	registerExternalAgentStatusRaiseErrorCallback func() bool
+	registerStateChangeCallback                   func(event AgentStateEvent)
 
 	// Inject sate
 	injectedAgentState *AgentState
@@ -159,10 +180,11 @@ func NewAgent(
 
 	// Core components
 	agent := &Agent{
-		Task:          task,
-		LLM:           llm,
-		Controller:    opts.controller,
-		SensitiveData: opts.sensitiveData,
+		Task:                task,
+		LLM:                 llm,
+		Controller:          opts.controller,
+		SensitiveData:       opts.sensitiveData,
+		DomainSensitiveData: opts.domainSensitiveData,
 	}
 
 	if agent.Controller == nil {
@@ -177,6 +199,9 @@ func NewAgent(
 		state = NewAgentState()
 	}
 	agent.State = state
+	if state.History != nil {
+		state.History.sensitiveData = utils.FlattenSensitiveData(agent.SensitiveData, agent.DomainSensitiveData)
+	}
 
 	// Action setup
 	agent.setupActionModels()
@@ -210,11 +235,12 @@ func NewAgent(
 		task,
 		systemPrompt.SystemMessage,
 		NewMessageManagerSettings(MessageManagerConfig{
-			"max_input_tokens":     agent.Settings.MaxInputTokens,
-			"include_attributes":   agent.Settings.IncludeAttributes,
-			"message_context":      agent.Settings.MessageContext,
-			"sensitive_data":       agent.SensitiveData,
-			"available_file_paths": agent.Settings.AvailableFilePaths,
+			"max_input_tokens":      agent.Settings.MaxInputTokens,
+			"include_attributes":    agent.Settings.IncludeAttributes,
+			"message_context":       agent.Settings.MessageContext,
+			"sensitive_data":        agent.SensitiveData,
+			"domain_sensitive_data": agent.DomainSensitiveData,
+			"available_file_paths":  agent.Settings.AvailableFilePaths,
 		}),
 		agent.State.MessageManagerState,
 	)
@@ -223,7 +249,8 @@ func NewAgent(
 	agent.InjectedBrowser = opts.browserInst != nil
 	agent.InjectedBrowserContext = opts.browserContext != nil
 	if opts.browserInst == nil {
-		opts.browserInst = browser.NewBrowser(browser.BrowserConfig{})
+		// the default config always validates
+		opts.browserInst, _ = browser.NewBrowser(browser.NewBrowserConfig())
 	}
 	agent.Browser = opts.browserInst
 	if opts.browserContext == nil {
@@ -235,6 +262,7 @@ func NewAgent(
 	agent.RegisterNewStepCallback = opts.registerNewStepCallback
 	agent.RegisterDoneCallback = opts.registerDoneCallback
 	agent.RegisterExternalAgentStatusRaiseErrorCallback = opts.registerExternalAgentStatusRaiseErrorCallback
+	agent.RegisterStateChangeCallback = opts.registerStateChangeCallback
 
 	return agent
 }
@@ -245,15 +273,13 @@ func (ag *Agent) convertInitialActions(actions []interface{}) []*controller.ActM
 }
 
 func (ag *Agent) setMessageContext() *string {
-	if ag.ToolCallingMethod != nil && *ag.ToolCallingMethod == "raw" {
-		// For raw tool calling, only include actions with no filters initially
-		messageContext := ag.Settings.MessageContext
-		if messageContext != nil && len(*messageContext) > 0 {
-			*messageContext += fmt.Sprintf("\n\nAvailable actions: %s", ag.UnfilteredActions)
-		} else {
-			*messageContext = fmt.Sprintf("Available actions: %s", ag.UnfilteredActions)
+	if ag.ToolCallingMethod != nil && (*ag.ToolCallingMethod == Raw || *ag.ToolCallingMethod == JSONMode) {
+		// Without tool calling, only include actions with no filters initially
+		messageContext := fmt.Sprintf("Available actions: %s", ag.UnfilteredActions)
+		if ag.Settings.MessageContext != nil && len(*ag.Settings.MessageContext) > 0 {
+			messageContext = *ag.Settings.MessageContext + "\n\n" + messageContext
 		}
-		ag.Settings.MessageContext = messageContext
+		ag.Settings.MessageContext = &messageContext
 	}
 	return ag.Settings.MessageContext
 }
@@ -266,12 +292,15 @@ func (ag *Agent) logAgentRun() {
 func (ag *Agent) logAgentInfo() {
 	log.Printf("🧠 Starting an agent with main_model=%s", ag.ModelName)
 
-	if ag.ToolCallingMethod != nil && *ag.ToolCallingMethod == "function_calling" {
+	if ag.ToolCallingMethod != nil && *ag.ToolCallingMethod == FunctionCalling {
 		log.Printf(" +tools")
 	}
-	if ag.ToolCallingMethod != nil && *ag.ToolCallingMethod == "raw" {
+	if ag.ToolCallingMethod != nil && *ag.ToolCallingMethod == Raw {
 		log.Printf(" +rawtools")
 	}
+	if ag.ToolCallingMethod != nil && *ag.ToolCallingMethod == JSONMode {
+		log.Printf(" +jsonmode")
+	}
 	if ag.Settings.UseVision {
 		log.Printf(" +vision")
 	}
@@ -316,15 +345,11 @@ func (ag *Agent) setToolCallingMethod() *ToolCallingMethod {
 		return nil
 	}
 	if *toolCallingMethod == Auto {
-		switch {
-		case strings.Contains(ag.ModelName, "openai") ||
-			strings.Contains(ag.ModelName, "googleai") ||
-			strings.Contains(ag.ModelName, "anthropic"):
-			fc := FunctionCalling
-			return &fc
-		default:
-			return nil
+		method := FunctionCalling
+		if m, ok := autoToolCallingMethods[ag.ModelName]; ok {
+			method = m
 		}
+		return &method
 	} else {
 		return toolCallingMethod
 	}
@@ -339,31 +364,124 @@ func (ag *Agent) setupActionModels() {
 	ag.AgentOutput = ToolInfoWithCustomActions(ag.ActionModel)
 
 	// used to force the done action when max steps is reached
-	ag.DoneActionModel = ag.Controller.Registry.CreateActionModel([]string{"Done"}, nil)
+	ag.DoneActionModel = ag.Controller.Registry.CreateActionModel([]string{"done"}, nil)
 	ag.DoneAgentOutput = ToolInfoWithCustomActions(ag.DoneActionModel)
 }
 
+// Record that the step was paused or stopped before it ran any action
 func (ag *Agent) handleInterrupt() {
 	newActionResult := controller.NewActionResult()
-	newActionResult.Error = playwright.String("The agent was paused with Ctrl+C")
+	newActionResult.Error = playwright.String("The agent was paused")
 	newActionResult.IncludeInMemory = true
 
 	ag.State.LastResult = []*controller.ActionResult{newActionResult}
+	ag.control.stepInterrupted = true
+}
+
+// Record that the run was cancelled through its context, so the result is not mistaken for a finished action
+func (ag *Agent) handleCancel(err error) {
+	ag.State.LastResult = []*controller.ActionResult{newInterruptedResult(err)}
+}
+
+func newInterruptedResult(err error) *controller.ActionResult {
+	actionResult := controller.NewActionResult()
+	actionResult.Error = playwright.String(fmt.Sprintf("Interrupted: %s", err))
+	actionResult.IncludeInMemory = true
+	return actionResult
+}
+
+// Count a failed step and feed the error back to the model in the next step.
+// Rate limits and network errors are waited out with an exponential backoff starting at RetryDelay.
+// The returned error is an *AgentError unless ctx was done during the backoff.
+func (ag *Agent) handleStepError(ctx context.Context, err error) error {
+	agentErr := classifyError(err)
+	ag.State.ConsecutiveFailures++
+	prefix := fmt.Sprintf("❌ Result failed %d/%d times:\n ", ag.State.ConsecutiveFailures, ag.Settings.MaxFailures)
+
+	ag.State.LastResult = []*controller.ActionResult{{
+		Error:           playwright.String(agentErr.Message()),
+		IncludeInMemory: true,
+	}}
+
+	if !agentErr.IsTransient() {
+		log.Errorf("%s%s", prefix, agentErr)
+		return agentErr
+	}
+	log.Warnf("%s%s", prefix, agentErr)
+	if ag.State.ConsecutiveFailures >= ag.Settings.MaxFailures {
+		// the run is stopped anyway, no need to wait
+		return agentErr
+	}
+	delay := retryBackoff(time.Duration(ag.Settings.RetryDelay)*time.Second, ag.State.ConsecutiveFailures)
+	log.Infof("⏳ Waiting %s before retrying", delay)
+	if err := utils.Sleep(ctx, delay); err != nil {
+		ag.handleCancel(err)
+		return err
+	}
+	return agentErr
+}
+
+// Whether every action of a step returned an error
+func allActionsFailed(results []*controller.ActionResult) bool {
+	if len(results) == 0 {
+		return false
+	}
+	for _, result := range results {
+		if result == nil || result.Error == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Delay before the retry after the given number of consecutive failures, doubled for each failure
+func retryBackoff(delay time.Duration, failures int) time.Duration {
+	if delay <= 0 || failures <= 1 {
+		return max(delay, 0)
+	}
+	const maxDelay = 5 * time.Minute
+	for range failures - 1 {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	return delay
 }
 
 func (ag *Agent) Step(stepInfo *AgentStepInfo) error {
+	return ag.StepContext(context.Background(), stepInfo)
+}
+
+// StepContext is Step, ctx is passed on to the LLM calls, the actions and the waits for the page.
+// Once ctx is done no new action is started and an interrupted result is recorded instead.
+// A failed llm call or action is returned as *AgentError, see handleStepError.
+func (ag *Agent) StepContext(ctx context.Context, stepInfo *AgentStepInfo) error {
 	// Execute one step of the task
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ag.control.stepInterrupted = false
 	log.Infof("📍 Step %d\n", ag.State.NSteps)
 	stepStartTime := time.Now().UnixNano()
 
-	browserState := ag.BrowserContext.GetState(true)
-	activePage := ag.BrowserContext.GetCurrentPage()
+	browserState, err := ag.BrowserContext.GetStateContext(ctx, true)
+	if err != nil {
+		if ctx.Err() != nil {
+			ag.handleCancel(ctx.Err())
+		}
+		return err
+	}
+	activePage, err := ag.BrowserContext.GetCurrentPage()
+	if err != nil {
+		return err
+	}
 
 	// TODO(MID): generate procedural memory if needed
 	// if self.settings.enable_memory and self.memory and self.state.n_steps % self.settings.memory_interval == 0:
 	// 	self.memory.create_procedural_memory(self.state.n_steps)
 
-	err := ag.raiseIfStoppedOrPaused()
+	err = ag.raiseIfStoppedOrPaused()
 	if err != nil {
 		ag.handleInterrupt()
 		return nil
@@ -384,39 +502,21 @@ func (ag *Agent) Step(stepInfo *AgentStepInfo) error {
 		}, nil, nil)
 	}
 
-	// TODO(MID): should check after support deepseek model
-	// If using raw tool calling method, we need to update the message context with new actions
-	// if *ag.ToolCallingMethod == "raw" {
-	// 	// For raw tool calling, get all non-filtered actions plus the page-filtered ones
-	// 	allActions := ag.Controller.Registry.GetPromptDescription(nil)
-	// 	if pageFilteredActions != "" {
-	// 		allActions += "\n" + pageFilteredActions
-	// 	}
-
-	// 	contextLines := strings.Split(*ag.MessageManager.Settings.MessageContext, "\n")
-	// 	var nonActionLines []string
-	// 	for _, line := range contextLines {
-	// 		if !strings.Contains(line, "Available actions:") {
-	// 			nonActionLines = append(nonActionLines, line)
-	// 		}
-	// 	}
-	// 	updatedContext := strings.Join(nonActionLines, "\n")
-	// 	if updatedContext != "" {
-	// 		updatedContext += "\n\nAvailable actions: " + allActions
-	// 	} else {
-	// 		updatedContext = "Available actions: " + allActions
-	// 	}
-	// 	ag.MessageManager.Settings.MessageContext = playwright.string(updatedContext)
-	// }
-
+	// Without tool calling the page-specific actions reach the model through the message above
+	// and the AgentOutput schema, see getNextActionWithoutTools
 	ag.MessageManager.AddStateMessage(browserState, ag.State.LastResult, stepInfo, ag.Settings.UseVision)
 
-	// TODO(MID): support planner
 	// Run planner at specified intervals if planner is configured
-	// if self.settings.planner_llm and self.state.n_steps % self.settings.planner_interval == 0:
-	// 	plan = await self._run_planner()
-	// 	# add plan before last state message
-	// 	self._message_manager.add_plan(plan, position=-1)
+	if ag.Settings.PlannerLLM != nil && ag.Settings.PlannerInterval > 0 && ag.State.NSteps%ag.Settings.PlannerInterval == 0 {
+		plan, err := ag.runPlanner(ctx, pageFilteredActions)
+		if err != nil {
+			log.Warnf("Planner failed: %s", err)
+		} else {
+			ag.State.LastPlan = plan
+			// add plan before last state message
+			ag.MessageManager.AddPlan(plan, playwright.Int(-1))
+		}
+	}
 
 	if stepInfo != nil && stepInfo.IsLastStep() {
 		// Add last step warning if needed
@@ -435,10 +535,14 @@ func (ag *Agent) Step(stepInfo *AgentStepInfo) error {
 	inputMessages := ag.MessageManager.GetMessages()
 	tokens := ag.MessageManager.State.History.CurrentTokens
 
-	modelOutput, err := ag.GetNextAction(inputMessages)
+	modelOutput, err := ag.GetNextActionContext(ctx, inputMessages)
 	if err != nil {
 		ag.MessageManager.RemoveLastStateMessage()
-		return errors.New("failed to get next action")
+		if ctx.Err() != nil {
+			ag.handleCancel(ctx.Err())
+			return ctx.Err()
+		}
+		return ag.handleStepError(ctx, err)
 	}
 
 	// Check again for paused/stopped state after getting model output
@@ -467,23 +571,42 @@ func (ag *Agent) Step(stepInfo *AgentStepInfo) error {
 	if err != nil {
 		ag.handleInterrupt()
 		ag.MessageManager.RemoveLastStateMessage()
+		// no action was run, the step is repeated once the agent is resumed
+		ag.State.NSteps--
 		return nil
 	}
 
 	ag.MessageManager.AddModelOutput(modelOutput)
 
-	result, err := ag.MultiAct(modelOutput.Actions, true)
-	if err != nil {
-		// TODO(MID): complement error handling
-		errStr := err.Error()
-		ag.State.LastResult = []*controller.ActionResult{
-			{
-				Error:           &errStr,
-				IncludeInMemory: false,
-			},
-		}
+	result, err := ag.MultiActContext(ctx, modelOutput.Actions, true)
+	if errors.Is(err, errInterrupted) {
+		// the agent was paused or stopped between two actions, the page may change before it is resumed
+		ag.makeHistoryItem(modelOutput, browserState, result, &StepMetadata{
+			StepNumber:    ag.State.NSteps,
+			StepStartTime: float64(stepStartTime),
+			StepEndTime:   float64(time.Now().UnixNano()),
+			InputTokens:   tokens,
+		})
+		ag.State.LastResult = []*controller.ActionResult{{
+			Error:           playwright.String("The agent was paused mid-step - the last action might need to be repeated"),
+			IncludeInMemory: false,
+		}}
+		return nil
+	}
+	if err != nil && ctx.Err() != nil {
+		// keep the actions which were executed before the cancellation in the history
+		ag.State.LastResult = result
+		ag.makeHistoryItem(modelOutput, browserState, result, &StepMetadata{
+			StepNumber:    ag.State.NSteps,
+			StepStartTime: float64(stepStartTime),
+			StepEndTime:   float64(time.Now().UnixNano()),
+			InputTokens:   tokens,
+		})
 		return err
 	}
+	if err != nil {
+		return ag.handleStepError(ctx, err)
+	}
 
 	ag.State.LastResult = result
 
@@ -494,7 +617,13 @@ func (ag *Agent) Step(stepInfo *AgentStepInfo) error {
 		}
 	}
 
-	ag.State.ConsecutiveFailures = 0
+	if allActionsFailed(result) {
+		// the model gets the errors in the next step, but the step did not get the task any further
+		ag.State.ConsecutiveFailures++
+		log.Warnf("❌ All actions failed %d/%d times", ag.State.ConsecutiveFailures, ag.Settings.MaxFailures)
+	} else {
+		ag.State.ConsecutiveFailures = 0
+	}
 
 	if len(result) == 0 {
 		return nil
@@ -513,6 +642,54 @@ func (ag *Agent) Step(stepInfo *AgentStepInfo) error {
 	return nil
 }
 
+// Run the planner to analyze state and suggest next steps
+func (ag *Agent) runPlanner(ctx context.Context, pageFilteredActions string) (*string, error) {
+	if ag.Settings.PlannerLLM == nil {
+		return nil, nil
+	}
+
+	allActions := ag.Controller.Registry.GetPromptDescription(nil)
+	if pageFilteredActions != "" {
+		allActions += "\n" + pageFilteredActions
+	}
+
+	plannerMessages := ag.buildPlannerMessages(allActions)
+	response, err := ag.Settings.PlannerLLM.Generate(ctx, plannerMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := response.Content
+	if ag.Settings.IsPlannerReasoning {
+		plan = removeThinkTags(plan)
+	}
+
+	var planJson map[string]interface{}
+	if err := json.Unmarshal([]byte(plan), &planJson); err == nil {
+		pretty, _ := json.MarshalIndent(planJson, "", "    ")
+		log.Infof("Planning Analysis:\n%s", string(pretty))
+	} else {
+		log.Infof("Planning Analysis:\n%s", plan)
+	}
+	return &plan, nil
+}
+
+// Build planner input from the conversation, replacing the agent system prompt with the planner prompt
+func (ag *Agent) buildPlannerMessages(allActions string) []*schema.Message {
+	plannerMessages := []*schema.Message{
+		NewPlannerPrompt(allActions).GetSystemMessage(ag.Settings.IsPlannerReasoning),
+	}
+	// use full message history except the first
+	messages := ag.MessageManager.GetMessages()
+	for _, msg := range messages[min(1, len(messages)):] {
+		if !ag.Settings.UseVisionForPlanner {
+			msg = textOnlyMessage(msg)
+		}
+		plannerMessages = append(plannerMessages, msg)
+	}
+	return plannerMessages
+}
+
 // TODO(MID): support deepseek
 // Convert input messages to the correct format
 // func (ag *Agent) convertInputMessages(inputMessages []*schema.Message) []*schema.Message {
@@ -521,65 +698,90 @@ func (ag *Agent) Step(stepInfo *AgentStepInfo) error {
 
 // Get next action from LLM based on current state
 func (ag *Agent) GetNextAction(inputMessages []*schema.Message) (*AgentOutput, error) {
-	// TODO(MID): support deepseek
+	return ag.GetNextActionContext(context.Background(), inputMessages)
+}
+
+// GetNextActionContext is GetNextAction, ctx is passed on to the LLM
+func (ag *Agent) GetNextActionContext(ctx context.Context, inputMessages []*schema.Message) (*AgentOutput, error) {
 	// TODO(MID): support other models like gemini, hugginface
+	if ag.ToolCallingMethod != nil && (*ag.ToolCallingMethod == Raw || *ag.ToolCallingMethod == JSONMode) {
+		return ag.getNextActionWithoutTools(ctx, inputMessages)
+	}
 
 	toolLLM, err := ag.LLM.WithTools([]*schema.ToolInfo{ag.AgentOutput})
 	if err != nil {
 		return nil, err
 	}
 	// log.Debug("Using %s for %s", *ag.ToolCallingMethod, ag.ChatModelLibrary)
-	response, err := toolLLM.Generate(context.Background(), inputMessages, model.WithToolChoice(schema.ToolChoiceForced))
+	response, err := toolLLM.Generate(ctx, inputMessages, model.WithToolChoice(schema.ToolChoiceForced))
 	if err != nil {
 		return nil, err
 	}
 
 	toolCalls := response.ToolCalls
 	if len(toolCalls) == 0 {
-		return nil, errors.New("no tool calls")
+		if response.Content != "" {
+			// some models answer with the arguments as content instead of calling the tool
+			log.Printf("Model output: %s\n", response.Content)
+			return parseAgentOutput(response.Content, ag.currentActionModel())
+		}
+		return nil, newParseError(errors.New("no tool calls"))
 	}
 	toolCall := toolCalls[0]
 
-	var parsed AgentOutput
 	toolCallName := toolCall.Function.Name
 	if toolCallName == "" {
-		return nil, errors.New("failed to get tool call name")
+		return nil, newParseError(errors.New("failed to get tool call name"))
 	}
 	toolCallArgs := toolCall.Function.Arguments
 	if toolCallArgs == "" {
-		return nil, errors.New("failed to get tool call args")
+		return nil, newParseError(errors.New("failed to get tool call args"))
 	}
 	log.Printf("Tool call args: %s\n", toolCallArgs)
 
-	err = json.Unmarshal([]byte(toolCallArgs), &parsed)
-	if err != nil {
-		log.Debug("failed to unmarshal tool call args: %s", toolCallArgs)
-		// currentState := map[string]interface{}{
-		// 	"page_summary":             "Processing tool call",
-		// 	"evaluation_previous_goal": "Executing action",
-		// 	"memory":                   "Using tool call",
-		// 	"next_goal":                fmt.Sprintf("Execute %s", toolCallName),
-		// }
+	return parseAgentOutput(toolCallArgs, ag.currentActionModel())
+}
 
-		// // Create action from tool call
-		// action := map[string]interface{}{
-		// 	toolCallName: toolCallArgs,
-		// }
+// Get the next action of a model without function calling, e.g. a local model served by ollama or llama.cpp.
+// The schema of AgentOutput is appended to the prompt and the JSON object is extracted from the response.
+// In JSONMode the model is told that its response format is JSON, see JSONMode for the model configuration.
+func (ag *Agent) getNextActionWithoutTools(ctx context.Context, inputMessages []*schema.Message) (*AgentOutput, error) {
+	jsonMode := ag.ToolCallingMethod != nil && *ag.ToolCallingMethod == JSONMode
+	schemaPrompt, err := agentOutputSchemaPrompt(ag.AgentOutput, jsonMode)
+	if err != nil {
+		return nil, err
 	}
+	messages := append(convertInputMessages(inputMessages), &schema.Message{
+		Role:    schema.User,
+		Content: schemaPrompt,
+	})
+	response, err := ag.LLM.Generate(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Model output: %s\n", response.Content)
 
-	return &parsed, nil
+	return parseAgentOutput(response.Content, ag.currentActionModel())
+}
+
+// Action model the output of the model is validated against, only done is allowed in the last step
+func (ag *Agent) currentActionModel() *controller.ActionModel {
+	if ag.AgentOutput != nil && ag.AgentOutput == ag.DoneAgentOutput {
+		return ag.DoneActionModel
+	}
+	return ag.ActionModel
 }
 
 func (ag *Agent) raiseIfStoppedOrPaused() error {
 	if ag.RegisterExternalAgentStatusRaiseErrorCallback != nil {
 		log.Debug("raiseIfStoppedOrPaused")
 		if ag.RegisterExternalAgentStatusRaiseErrorCallback() {
-			return errors.New("interrupted")
+			return errInterrupted
 		}
 	}
-	if ag.State.Stopped || ag.State.Paused {
+	if ag.IsStopped() || ag.IsPaused() {
 		log.Debug("raiseIfStoppedOrPaused")
-		return errors.New("interrupted")
+		return errInterrupted
 	}
 	return nil
 }
@@ -598,44 +800,54 @@ func (ag *Agent) updateActionModelsForPage(page playwright.Page) {
 }
 
 func (ag *Agent) Run(maxSteps int, onStepStart func(*Agent), onStepEnd func(*Agent)) (*AgentHistoryList, error) {
-	defer ag.Close()
-	// TODO(LOW): implement signal handler (Set up the Ctrl+C signal handler with callbacks specific to this agent)
+	return ag.RunContext(context.Background(), maxSteps, onStepStart, onStepEnd)
+}
+
+// RunContext is Run with a context, e.g. to put a deadline on the run.
+// When ctx is done the run stops after the current action and returns the history so far with the error of ctx.
+func (ag *Agent) RunContext(ctx context.Context, maxSteps int, onStepStart func(*Agent), onStepEnd func(*Agent)) (*AgentHistoryList, error) {
+	defer func() {
+		if err := ag.Close(); err != nil {
+			log.Errorf("Error during cleanup: %s", err)
+		}
+	}()
+	if ag.Settings.HandleSignals {
+		handler := newSignalHandler(ag, os.Stdin)
+		handler.start()
+		defer handler.close()
+	}
 	// TODO(LOW): implement verification llm (Wait for verification task to complete if it exists)
-	// TODO(LOW): implement generate gif
 
 	ag.logAgentRun()
 
 	// Execute initial actions if provided
 	if len(ag.InitialActions) > 0 {
-		result, err := ag.MultiAct(ag.InitialActions, false)
+		result, err := ag.MultiActContext(ctx, ag.InitialActions, false)
 		if err != nil {
+			if ctx.Err() != nil {
+				return ag.State.History, err
+			}
 			return nil, err
 		}
 		ag.State.LastResult = result
 	}
 
 	stepCheck := 0
-	for step := range maxSteps {
-		if ag.State.Paused {
-			// TODO(LOW): implement signal handler
-			// signal_handler.wait_for_resume()
-			// signal_handler.reset()
-		}
+	for step := 0; step < maxSteps; step++ {
 		if ag.State.ConsecutiveFailures >= ag.Settings.MaxFailures {
-			log.Fatalf("❌ Stopping due to %d consecutive failures", ag.Settings.MaxFailures)
+			log.Errorf("❌ Stopping due to %d consecutive failures", ag.Settings.MaxFailures)
 			break
 		}
 
-		if ag.State.Stopped {
+		// the browser is free to be used by someone else while the agent is paused
+		ag.waitWhilePaused(ctx.Done())
+		if ag.IsStopped() {
 			log.Printf("Agent stopped")
 			break
 		}
-
-		for ag.State.Paused {
-			time.Sleep(200 * time.Millisecond)
-			if ag.State.Stopped {
-				break
-			}
+		if err := ctx.Err(); err != nil {
+			log.Warnf("Agent run cancelled: %s", err)
+			return ag.State.History, err
 		}
 
 		if onStepStart != nil {
@@ -646,11 +858,24 @@ func (ag *Agent) Run(maxSteps int, onStepStart func(*Agent), onStepEnd func(*Age
 			StepNumber: step,
 			MaxSteps:   maxSteps,
 		}
-		err := ag.Step(stepInfo)
-		if err != nil {
+		err := ag.StepContext(ctx, stepInfo)
+		if err != nil && ctx.Err() != nil {
+			log.Warnf("Agent run cancelled in step %d: %s", step, err)
+			return ag.State.History, ctx.Err()
+		}
+		var agentErr *AgentError
+		if errors.As(err, &agentErr) {
+			// counted in ConsecutiveFailures, the run stops once MaxFailures is reached
+			log.Debugf("Step %d failed with a %s error", step, agentErr.Kind)
+		} else if err != nil {
 			log.Errorf("❌ Step %d failed: %s", step, err)
 			return nil, err
 		}
+		if ag.control.stepInterrupted {
+			// the step was paused or stopped before it ran any action, it does not count against maxSteps
+			step--
+			continue
+		}
 
 		if onStepEnd != nil {
 			onStepEnd(ag)
@@ -658,7 +883,7 @@ func (ag *Agent) Run(maxSteps int, onStepStart func(*Agent), onStepEnd func(*Age
 
 		if ag.State.History.IsDone() {
 			if ag.Settings.ValidateOutput && step < maxSteps-1 {
-				if !ag.validateOutput() {
+				if !ag.validateOutput(ctx) {
 					continue
 				}
 			}
@@ -672,30 +897,55 @@ func (ag *Agent) Run(maxSteps int, onStepStart func(*Agent), onStepEnd func(*Age
 		log.Info("❌ Failed to complete task in maximum steps")
 	}
 
+	if ag.Settings.GenerateGif {
+		if err := CreateHistoryGif(ag.Task, ag.State.History, ag.Settings.GifPath); err != nil {
+			log.Errorf("Failed to create GIF: %s", err)
+		}
+	}
+
 	return ag.State.History, nil
 }
 
 // Close all resources
-func (ag *Agent) Close() {
+func (ag *Agent) Close() error {
 	// First close browser resources
 	var err error
-	if ag.BrowserContext != nil && !ag.InjectedBrowserContext {
-		ag.BrowserContext.Close()
+	if ag.BrowserContext != nil {
+		if !ag.InjectedBrowserContext {
+			ag.BrowserContext.Close()
+		}
+		// an injected context is closed by its owner, only then are the videos saved and the HAR file complete,
+		// see BrowserContext.RecordingPaths and BrowserContext.HarPath
+		ag.State.History.RecordingPaths = ag.BrowserContext.RecordingPaths
+		if ag.BrowserContext.HarPath != "" {
+			ag.State.History.HarPath = &ag.BrowserContext.HarPath
+		}
 	}
 	if ag.Browser != nil && !ag.InjectedBrowser {
 		err = ag.Browser.Close()
 	}
-	if err != nil {
-		log.Fatalf("Error during cleanup: %s", err)
-	}
+	return err
 }
 
 // Execute multiple actions
 func (ag *Agent) MultiAct(
 	actions []*controller.ActModel,
 	checkForNewElements bool,
+) ([]*controller.ActionResult, error) {
+	return ag.MultiActContext(context.Background(), actions, checkForNewElements)
+}
+
+// MultiActContext is MultiAct with a context. Actions are never cut off halfway, ctx is checked before each one.
+// When ctx is done the results so far are returned with an interrupted result appended and the error of ctx.
+func (ag *Agent) MultiActContext(
+	ctx context.Context,
+	actions []*controller.ActModel,
+	checkForNewElements bool,
 ) ([]*controller.ActionResult, error) {
 	results := []*controller.ActionResult{}
+	if len(ag.DomainSensitiveData) > 0 {
+		ctx = controller.WithDomainSensitiveData(ctx, ag.DomainSensitiveData)
+	}
 
 	cachedSelectorMap := ag.BrowserContext.GetSelectorMap()
 	cachedPathHashes := mapset.NewSet[string]()
@@ -708,19 +958,34 @@ func (ag *Agent) MultiAct(
 	ag.BrowserContext.RemoveHighlights()
 
 	for i, action := range actions {
+		if err := ctx.Err(); err != nil {
+			log.Warnf("Action %d / %d was cancelled: %s", i+1, len(actions), err)
+			return append(results, newInterruptedResult(err)), err
+		}
 		if action.GetIndex() != nil && i != 0 {
-			newState := ag.BrowserContext.GetState(false)
+			newState, err := ag.BrowserContext.GetStateContext(ctx, false)
+			if err != nil {
+				if ctx.Err() != nil {
+					return append(results, newInterruptedResult(ctx.Err())), ctx.Err()
+				}
+				return results, err
+			}
 			newSelectorMap := newState.SelectorMap
 
 			// Detect index change after previous action
 			index := action.GetIndex()
 			if index != nil {
-				origTarget := (*cachedSelectorMap)[*index]
+				var origTarget, newTarget *dom.DOMElementNode
+				if cachedSelectorMap != nil {
+					origTarget = (*cachedSelectorMap)[*index]
+				}
 				var origTargetHash *string = nil
 				if origTarget != nil {
 					origTargetHash = playwright.String(origTarget.Hash().BranchPathHash)
 				}
-				newTarget := (*newSelectorMap)[*index]
+				if newSelectorMap != nil {
+					newTarget = (*newSelectorMap)[*index]
+				}
 				var newTargetHash *string = nil
 				if newTarget != nil {
 					newTargetHash = playwright.String(newTarget.Hash().BranchPathHash)
@@ -749,30 +1014,49 @@ func (ag *Agent) MultiAct(
 			}
 		}
 
-		ag.raiseIfStoppedOrPaused()
-		result, err := ag.Controller.ExecuteAction(action, ag.BrowserContext, ag.Settings.PageExtractionLLM, ag.SensitiveData, ag.Settings.AvailableFilePaths)
+		if err := ag.raiseIfStoppedOrPaused(); err != nil {
+			log.Printf("Action %d / %d was cancelled because the agent was paused or stopped", i+1, len(actions))
+			if len(results) > 0 {
+				results = append(results, &controller.ActionResult{Error: playwright.String("The action was cancelled because the agent was paused or stopped"), IncludeInMemory: true})
+			}
+			return results, err
+		}
+		result, err := ag.Controller.ExecuteActionContext(ctx, action, ag.BrowserContext, ag.Settings.PageExtractionLLM, ag.SensitiveData, ag.Settings.AvailableFilePaths)
+		if err != nil && ctx.Err() != nil {
+			return append(results, newInterruptedResult(ctx.Err())), ctx.Err()
+		}
 		if err != nil {
 			return nil, err
-			// TODO(LOW): implement signal handler error
-			// log.Printf("Action %d was cancelled due to Ctrl+C", i+1)
-			// if len(results) > 0 {
-			// 	results = append(results, &controller.ActionResult{Error: playwright.String("The action was cancelled due to Ctrl+C"), IncludeInMemory: true})
-			// }
-			// return nil, errors.New("Action cancelled by user")
 		}
 		results = append(results, result)
+		ag.addDownloadedFiles()
 		log.Debugf("Executed action %d / %d", i+1, len(actions))
 		lastIndex := len(results) - 1
 		if (results[lastIndex].IsDone != nil && *results[lastIndex].IsDone) || results[lastIndex].Error != nil || i == len(actions)-1 {
 			break
 		}
 
-		time.Sleep(500 * time.Millisecond) // ag.BrowserContext.Config.WaitBetweenActions
+		if err := utils.Sleep(ctx, 500*time.Millisecond); err != nil { // ag.BrowserContext.Config.WaitBetweenActions
+			log.Warnf("Action %d / %d was cancelled: %s", i+2, len(actions), err)
+			return append(results, newInterruptedResult(err)), err
+		}
 	}
 
 	return results, nil
 }
 
+// Make files downloaded by the browser available to actions such as upload_file
+func (ag *Agent) addDownloadedFiles() {
+	for _, path := range ag.BrowserContext.DownloadedFiles {
+		if slices.Contains(ag.Settings.AvailableFilePaths, path) {
+			continue
+		}
+		ag.Settings.AvailableFilePaths = append(ag.Settings.AvailableFilePaths, path)
+		ag.MessageManager.Settings.AvailableFilePaths = append(ag.MessageManager.Settings.AvailableFilePaths, path)
+		log.Infof("📁  Added download to available file paths: %s", path)
+	}
+}
+
 // Create and store history item
 func (ag *Agent) makeHistoryItem(
 	modelOutput *AgentOutput,
@@ -791,6 +1075,7 @@ func (ag *Agent) makeHistoryItem(
 		Title:             browserState.Title,
 		Tabs:              browserState.Tabs,
 		InteractedElement: interactedElements,
+		Screenshot:        browserState.Screenshot,
 	}
 
 	historyItem := &AgentHistory{
@@ -803,51 +1088,68 @@ func (ag *Agent) makeHistoryItem(
 	ag.State.History.History = append(ag.State.History.History, historyItem)
 }
 
-//	type validationOutput struct {
-//		IsValid bool
-//		Reason  string
-//	}
-//
 // Validate the output of the last action is what the user wanted
-func (ag *Agent) validateOutput() bool {
-	// TODO(MID): implement output validator
-	return true
-	// systemMsg := fmt.Sprintf(
-	// 	"You are a validator of an agent who interacts with a browser." +
-	// 	"Validate if the output of last action is what the user wanted and if the task is completed." +
-	// 	"If the task is unclear defined, you can let it pass. But if something is missing or the image does not show what was requested dont let it pass." +
-	// 	"Try to understand the page and help the model with suggestions like scroll, do x, ... to get the solution right." +
-	// 	"Task to validate: %s. Return a JSON object with 2 keys: is_valid and reason." +
-	// 	"is_valid is a boolean that indicates if the output is correct." +
-	// 	"reason is a string that explains why it is valid or not." +
-	// 	"reason is a string that explains why it is valid or not." +
-	// 	" example: {{\"is_valid\": false, \"reason\": \"The user wanted to search for \"cat photos\", but the agent searched for \"dog photos\" instead.\"}}",
-	// 	ag.Task)
-
-	// if ag.BrowserContext.Session != nil {
-	// 	state :=  ag.BrowserContext.GetState(false)
-	// 	content := AgentMessagePrompt{
-	// 		State: state,
-	// 		Result: ag.State.LastResult,
-	// 		IncludeAttributes: ag.Settings.IncludeAttributes,
-	// 	}
-	// 	msg := []*schema.Message{schema.Message{Role: schema.System, Content: systemMsg}, content.GetUserMessage(ag.Settings.UseVision)}
-	// } else {
-	// 	return true
-	// }
-
-	// validator := ag.LLM.GenerateContent(ValidationResult, true)
-	// response := validator.ainvoke(msg)
-	// parsed := response.Parsed()
-	// is_valid := parsed.IsValid
-	// if !is_valid {
-	// 	log.Printf("❌ Validator decision: %s", parsed.Reason)
-	// 	msg := fmt.Sprintf("The output is not yet correct. %s.", parsed.Reason)
-	// 	ag.State.LastResult = []*controller.ActionResult{controller.ActionResult{ExtractedContent: &msg, IncludeInMemory: true}}
-	// } else {
-	// 	log.Printf("✅ Validator decision: %s", parsed.Reason)
-	// }
-	// return is_valid
+func (ag *Agent) validateOutput(ctx context.Context) bool {
+	if ag.BrowserContext.Session == nil {
+		return true
+	}
+
+	systemMsg := "You are a validator of an agent who interacts with a browser. " +
+		"Validate if the output of last action is what the user wanted and if the task is completed. " +
+		"If the task is unclear defined, you can let it pass. But if something is missing or the image does not show what was requested dont let it pass. " +
+		"Try to understand the page and help the model with suggestions like scroll, do x, ... to get the solution right. " +
+		fmt.Sprintf("Task to validate: %s. Return a JSON object with 2 keys: is_valid and reason. ", ag.Task) +
+		"is_valid is a boolean that indicates if the output is correct. " +
+		"reason is a string that explains why it is valid or not. " +
+		`example: {"is_valid": false, "reason": "The user wanted to search for "cat photos", but the agent searched for "dog photos" instead."}`
+
+	state, err := ag.BrowserContext.GetStateContext(ctx, false)
+	if err != nil {
+		log.Warnf("Failed to get state for validation, accepting output: %s", err)
+		return true
+	}
+	content := NewAgentMessagePrompt(state, ag.State.LastResult, ag.Settings.IncludeAttributes, nil)
+	msg := []*schema.Message{
+		{Role: schema.System, Content: systemMsg},
+		content.GetUserMessage(ag.Settings.UseVision),
+	}
+
+	parsed, err := ag.askValidator(ctx, msg)
+	if err != nil {
+		// don't block completion because the validator itself failed
+		log.Warnf("Validator failed, accepting output: %s", err)
+		return true
+	}
+
+	if !parsed.IsValid {
+		log.Printf("❌ Validator decision: %s", parsed.Reason)
+		msg := fmt.Sprintf("The output is not yet correct. %s.", parsed.Reason)
+		ag.State.LastResult = []*controller.ActionResult{{ExtractedContent: &msg, IncludeInMemory: true}}
+	} else {
+		log.Printf("✅ Validator decision: %s", parsed.Reason)
+	}
+	return parsed.IsValid
+}
+
+// Ask the LLM for a validation result with a forced tool call
+func (ag *Agent) askValidator(ctx context.Context, inputMessages []*schema.Message) (*ValidationResult, error) {
+	toolLLM, err := ag.LLM.WithTools([]*schema.ToolInfo{ValidationResultToolInfo()})
+	if err != nil {
+		return nil, err
+	}
+	response, err := toolLLM.Generate(ctx, inputMessages, model.WithToolChoice(schema.ToolChoiceForced))
+	if err != nil {
+		return nil, err
+	}
+	if len(response.ToolCalls) == 0 {
+		return nil, errors.New("no tool calls")
+	}
+
+	var parsed ValidationResult
+	if err := json.Unmarshal([]byte(response.ToolCalls[0].Function.Arguments), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse validation result: %w", err)
+	}
+	return &parsed, nil
 }
 
 // Log the completion of the task
@@ -866,3 +1168,164 @@ func (ag *Agent) logCompletion() {
 		ag.RegisterDoneCallback(ag.State.History)
 	}
 }
+
+// Rerun a saved history of actions without calling the LLM.
+// Element indices are remapped to the current page, failed steps are retried
+// and either skipped or aborted depending on opts. Returns a fresh history of the replay.
+func (ag *Agent) RerunHistory(history *AgentHistoryList, opts *RerunHistoryOptions) (*AgentHistoryList, error) {
+	return ag.RerunHistoryContext(context.Background(), history, opts)
+}
+
+// RerunHistoryContext is RerunHistory with a context, the replay stops before the next action once ctx is done.
+// The steps replayed so far are returned with the error of ctx.
+func (ag *Agent) RerunHistoryContext(ctx context.Context, history *AgentHistoryList, opts *RerunHistoryOptions) (*AgentHistoryList, error) {
+	if opts == nil {
+		opts = NewRerunHistoryOptions()
+	}
+	maxRetries := max(opts.MaxRetries, 1)
+
+	// Execute initial actions if provided
+	if len(ag.InitialActions) > 0 {
+		result, err := ag.MultiActContext(ctx, ag.InitialActions, false)
+		if err != nil {
+			return nil, err
+		}
+		ag.State.LastResult = result
+	}
+
+	replayed := &AgentHistoryList{History: []*AgentHistory{}, sensitiveData: utils.FlattenSensitiveData(ag.SensitiveData, ag.DomainSensitiveData)}
+	for i, historyItem := range history.History {
+		if err := ctx.Err(); err != nil {
+			log.Warnf("Replay cancelled before step %d: %s", i+1, err)
+			return replayed, err
+		}
+		goal := ""
+		if historyItem.ModelOutput != nil && historyItem.ModelOutput.CurrentState != nil {
+			goal = historyItem.ModelOutput.CurrentState.NextGoal
+		}
+		log.Infof("Replaying step %d/%d: goal: %s", i+1, len(history.History), goal)
+
+		if historyItem.ModelOutput == nil || len(historyItem.ModelOutput.Actions) == 0 {
+			log.Warnf("Step %d: No action to replay, skipping", i+1)
+			replayed.History = append(replayed.History, &AgentHistory{
+				Result: []*controller.ActionResult{{Error: playwright.String("No action to replay")}},
+				State:  historyItem.State,
+			})
+			continue
+		}
+
+		for retryCount := 1; ; retryCount++ {
+			item, err := ag.executeHistoryStep(ctx, i+1, historyItem, opts.DelayBetweenActions)
+			if err == nil {
+				replayed.History = append(replayed.History, item)
+				break
+			}
+			if ctx.Err() != nil {
+				log.Warnf("Replay cancelled in step %d: %s", i+1, err)
+				return replayed, ctx.Err()
+			}
+			if retryCount < maxRetries {
+				log.Warnf("Step %d failed (attempt %d/%d), retrying...", i+1, retryCount, maxRetries)
+				if err := utils.Sleep(ctx, opts.DelayBetweenActions); err != nil {
+					return replayed, err
+				}
+				continue
+			}
+
+			errorMsg := fmt.Sprintf("Step %d failed after %d attempts: %s", i+1, maxRetries, err)
+			log.Error(errorMsg)
+			replayed.History = append(replayed.History, &AgentHistory{
+				ModelOutput: historyItem.ModelOutput,
+				Result:      []*controller.ActionResult{{Error: &errorMsg}},
+				State:       historyItem.State,
+			})
+			if !opts.SkipFailures {
+				return replayed, errors.New(errorMsg)
+			}
+			break
+		}
+	}
+
+	return replayed, nil
+}
+
+// Execute a single step from history with element validation, stepNumber is the number of the step in the replayed history
+func (ag *Agent) executeHistoryStep(ctx context.Context, stepNumber int, historyItem *AgentHistory, delay time.Duration) (*AgentHistory, error) {
+	stepStartTime := time.Now().UnixNano()
+	browserState, err := ag.BrowserContext.GetStateContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var interactedElements []*dom.DOMHistoryElement
+	if historyItem.State != nil {
+		interactedElements = historyItem.State.InteractedElement
+	}
+
+	updatedActions := []*controller.ActModel{}
+	for i, action := range historyItem.ModelOutput.Actions {
+		var historicalElement *dom.DOMHistoryElement
+		if i < len(interactedElements) {
+			historicalElement = interactedElements[i]
+		}
+		updatedAction := ag.updateActionIndices(historicalElement, action, browserState)
+		if updatedAction == nil {
+			return nil, fmt.Errorf("could not find matching element %d in current page", i)
+		}
+		updatedActions = append(updatedActions, updatedAction)
+	}
+
+	result, err := ag.MultiActContext(ctx, updatedActions, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.Sleep(ctx, delay); err != nil {
+		return nil, err
+	}
+
+	modelOutput := &AgentOutput{
+		CurrentState: historyItem.ModelOutput.CurrentState,
+		Actions:      updatedActions,
+	}
+	return &AgentHistory{
+		ModelOutput: modelOutput,
+		Result:      result,
+		State: &browser.BrowserStateHistory{
+			Url:               browserState.Url,
+			Title:             browserState.Title,
+			Tabs:              browserState.Tabs,
+			InteractedElement: GetInteractedElement(modelOutput, browserState.SelectorMap),
+		},
+		Metadata: &StepMetadata{
+			StepNumber:    stepNumber,
+			StepStartTime: float64(stepStartTime),
+			StepEndTime:   float64(time.Now().UnixNano()),
+		},
+	}, nil
+}
+
+// Update action indices based on the current page state.
+// Returns the updated action, or nil if the element cannot be found.
+func (ag *Agent) updateActionIndices(
+	historicalElement *dom.DOMHistoryElement,
+	action *controller.ActModel,
+	browserState *browser.BrowserState,
+) *controller.ActModel {
+	if historicalElement == nil || browserState.ElementTree == nil {
+		return action
+	}
+
+	currentElement := dom.HistoryTreeProcessor{}.FindHistoryElementInTree(historicalElement, browserState.ElementTree)
+	if currentElement == nil || currentElement.HighlightIndex == nil {
+		return nil
+	}
+
+	oldIndex := action.GetIndex()
+	if oldIndex != nil && *oldIndex != *currentElement.HighlightIndex {
+		// don't modify the saved history
+		action = action.Copy()
+		action.SetIndex(*currentElement.HighlightIndex)
+		log.Infof("Element moved in DOM, updated index from %d to %d", *oldIndex, *currentElement.HighlightIndex)
+	}
+	return action
+}
@@ -3,6 +3,16 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/nerdface-ai/browser-use-go/internals/controller"
 	"github.com/nerdface-ai/browser-use-go/internals/dom"
@@ -21,11 +31,21 @@ type ToolCallingMethod string
 
 const (
 	FunctionCalling ToolCallingMethod = "function_calling"
-	JSONMode        ToolCallingMethod = "json_mode"
-	Raw             ToolCallingMethod = "raw"
-	Auto            ToolCallingMethod = "auto"
+	// JSONMode expects a model created with a JSON response format, e.g. ResponseFormat json_object
+	// in openai.ChatModelConfig or Format "json" for ollama. eino has no option to set it per call.
+	JSONMode ToolCallingMethod = "json_mode"
+	Raw      ToolCallingMethod = "raw"
+	Auto     ToolCallingMethod = "auto"
 )
 
+// Tool calling method picked by Auto for model packages of backends without function calling,
+// every other provider uses FunctionCalling
+var autoToolCallingMethods = map[string]ToolCallingMethod{
+	"llamacpp":    Raw,
+	"llamafile":   Raw,
+	"huggingface": Raw,
+}
+
 // 2. REQUIRED_LLM_API_ENV_VARS: map[string][]string 으로 선언
 var REQUIRED_LLM_API_ENV_VARS = map[string][]string{"ChatOpenAI": {"OPENAI_API_KEY"}, "AzureOpenAI": {"AZURE_ENDPOINT", "AZURE_OPENAI_API_KEY"}, "ChatBedrockConverse": {"ANTHROPIC_API_KEY"}, "ChatAnthropic": {"ANTHROPIC_API_KEY"}, "ChatGoogleGenerativeAI": {"GEMINI_API_KEY"}, "ChatDeepSeek": {"DEEPSEEK_API_KEY"}, "ChatOllama": {}, "ChatGrok": {"GROK_API_KEY"}}
 
@@ -40,6 +60,7 @@ type AgentSettings struct {
 	ValidateOutput        bool                       `json:"validate_output"`
 	MessageContext        *string                    `json:"message_context,omitempty"`
 	GenerateGif           bool                       `json:"generate_gif"`
+	GifPath               string                     `json:"gif_path"`
 	AvailableFilePaths    []string                   `json:"available_file_paths"`
 	OverrideSystemMessage *string                    `json:"override_system_message,omitempty"`
 	ExtendSystemMessage   *string                    `json:"extend_system_message,omitempty"`
@@ -50,6 +71,7 @@ type AgentSettings struct {
 	PlannerLLM            model.ToolCallingChatModel `json:"planner_llm"`
 	PlannerInterval       int                        `json:"planner_interval"`
 	IsPlannerReasoning    bool                       `json:"is_planner_reasoning"`
+	HandleSignals         bool                       `json:"handle_signals"` // pause on Ctrl+C, see signalHandler
 
 	// Procedural memory settings
 	EnableMemory   bool                   `json:"enable_memory"`
@@ -70,6 +92,7 @@ func NewAgentSettings(config AgentSettingsConfig) *AgentSettings {
 		ValidateOutput:        utils.GetDefaultValue[bool](config, "validate_output", false),
 		MessageContext:        utils.GetDefaultValue[*string](config, "message_context", nil),
 		GenerateGif:           utils.GetDefaultValue[bool](config, "generate_gif", false),
+		GifPath:               utils.GetDefaultValue[string](config, "gif_path", "agent_history.gif"),
 		AvailableFilePaths:    utils.GetDefaultValue[[]string](config, "available_file_paths", nil),
 		OverrideSystemMessage: utils.GetDefaultValue[*string](config, "override_system_message", nil),
 		ExtendSystemMessage:   utils.GetDefaultValue[*string](config, "extend_system_message", nil),
@@ -91,6 +114,7 @@ func NewAgentSettings(config AgentSettingsConfig) *AgentSettings {
 		PlannerLLM:         utils.GetDefaultValue[model.ToolCallingChatModel](config, "planner_llm", nil),
 		PlannerInterval:    utils.GetDefaultValue[int](config, "planner_interval", 1),
 		IsPlannerReasoning: utils.GetDefaultValue[bool](config, "is_planner_reasoning", false),
+		HandleSignals:      utils.GetDefaultValue[bool](config, "handle_signals", false),
 		EnableMemory:       utils.GetDefaultValue[bool](config, "enable_memory", true),
 		MemoryInterval:     utils.GetDefaultValue[int](config, "memory_interval", 10),
 		MemoryConfig:       utils.GetDefaultValue[map[string]interface{}](config, "memory_config", nil),
@@ -205,12 +229,31 @@ func ToolInfoWithCustomActions(customActions *controller.ActionModel) *schema.To
 	}
 }
 
+// Output of the output validator
+type ValidationResult struct {
+	IsValid bool   `json:"is_valid" jsonschema:"description=Whether the output is correct and the task is completed"`
+	Reason  string `json:"reason" jsonschema:"description=Why the output is valid or not"`
+}
+
+func ValidationResultToolInfo() *schema.ToolInfo {
+	params, err := einoUtils.GoStruct2ParamsOneOf[ValidationResult]()
+	if err != nil {
+		log.Printf("Failed to get validation result schema: %v", err)
+		return nil
+	}
+	return &schema.ToolInfo{
+		Name:        "ValidationResult",
+		Desc:        "Validation result of the agent output",
+		ParamsOneOf: params,
+	}
+}
+
 // Metadata for a single step including timing and token information
 type StepMetadata struct {
-	StepStartTime float64
-	StepEndTime   float64
-	InputTokens   int
-	StepNumber    int
+	StepStartTime float64 `json:"step_start_time"`
+	StepEndTime   float64 `json:"step_end_time"`
+	InputTokens   int     `json:"input_tokens"`
+	StepNumber    int     `json:"step_number"`
 }
 
 // Calculate step duration in seconds
@@ -233,14 +276,15 @@ func GetInteractedElement(modelOutput *AgentOutput, selectorMap *dom.SelectorMap
 	elements := []*dom.DOMHistoryElement{}
 	for _, action := range modelOutput.Actions {
 		index := action.GetIndex()
-		if index != nil {
+		if index != nil && selectorMap != nil {
 			el := (*selectorMap)[*index]
 			if el != nil {
 				elements = append(elements, dom.HistoryTreeProcessor{}.ConvertDomElementToHistoryElement(el))
+				continue
 			}
-		} else {
-			elements = append(elements, nil)
 		}
+		// keep elements aligned with actions
+		elements = append(elements, nil)
 	}
 	return elements
 }
@@ -269,9 +313,14 @@ func (ah *AgentHistory) ModelDump() map[string]interface{} {
 		}
 	}
 
-	resultDump, err := utils.ModelDump(ah.Result)
-	if err != nil {
-		log.Printf("Failed to dump result: %v", err)
+	resultDump := []map[string]interface{}{}
+	for _, result := range ah.Result {
+		dump, err := utils.ModelDump(result)
+		if err != nil {
+			log.Printf("Failed to dump result: %v", err)
+			continue
+		}
+		resultDump = append(resultDump, dump)
 	}
 	stateDump, err := utils.ModelDump(ah.State)
 	if err != nil {
@@ -290,8 +339,75 @@ func (ah *AgentHistory) ModelDump() map[string]interface{} {
 	}
 }
 
+// Version of the file format written by AgentHistoryList.SaveToFile
+const HistoryFileVersion = 1
+
 type AgentHistoryList struct {
 	History []*AgentHistory `json:"history"`
+	// Video recordings and HAR file of the browser context, set once the agent is closed
+	RecordingPaths []string `json:"recording_paths,omitempty"`
+	HarPath        *string  `json:"har_path,omitempty"`
+	// Secret values replaced by their placeholders in ModelDump, set by the agent
+	sensitiveData map[string]string
+}
+
+type agentHistoryFile struct {
+	Version        int             `json:"version"`
+	History        []*AgentHistory `json:"history"`
+	RecordingPaths []string        `json:"recording_paths,omitempty"`
+	HarPath        *string         `json:"har_path,omitempty"`
+}
+
+// Save history to a versioned JSON file
+func (ahl *AgentHistoryList) SaveToFile(path string) error {
+	dump := ahl.ModelDump()
+	dump["version"] = HistoryFileVersion
+
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return err
+	}
+	// create folders if not exists
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Load history from a JSON file written by SaveToFile.
+// Every action is validated against the registered actions of actionModel.
+func LoadHistoryFromFile(path string, actionModel *controller.ActionModel) (*AgentHistoryList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file agentHistoryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse history file %s: %w", path, err)
+	}
+	if file.Version == 0 {
+		return nil, fmt.Errorf("history file %s has no version", path)
+	}
+	if file.Version > HistoryFileVersion {
+		return nil, fmt.Errorf("history file %s has unsupported version %d (supported: %d)", path, file.Version, HistoryFileVersion)
+	}
+
+	history := &AgentHistoryList{History: []*AgentHistory{}, RecordingPaths: file.RecordingPaths, HarPath: file.HarPath}
+	for i, item := range file.History {
+		if item == nil {
+			continue
+		}
+		if item.ModelOutput != nil && actionModel != nil {
+			for j, action := range item.ModelOutput.Actions {
+				if err := actionModel.ValidateAction(action); err != nil {
+					return nil, fmt.Errorf("step %d, action %d: %w", i+1, j+1, err)
+				}
+			}
+		}
+		history.History = append(history.History, item)
+	}
+	return history, nil
 }
 
 func (ahl *AgentHistoryList) LastResult() *ActionResult {
@@ -341,9 +457,53 @@ func (ahl *AgentHistoryList) ModelDump() map[string]interface{} {
 	for _, history := range ahl.History {
 		histories = append(histories, history.ModelDump())
 	}
-	return map[string]interface{}{
+	dump := map[string]interface{}{
 		"history": histories,
 	}
+	if len(ahl.RecordingPaths) > 0 {
+		dump["recording_paths"] = ahl.RecordingPaths
+	}
+	if ahl.HarPath != nil {
+		dump["har_path"] = *ahl.HarPath
+	}
+	if len(ahl.sensitiveData) > 0 {
+		// secrets typed by actions may be echoed in extracted content or errors
+		return filterSensitiveDataInDump(dump, ahl.sensitiveData).(map[string]interface{})
+	}
+	return dump
+}
+
+// Replace secret values in every string of a dump by their placeholders
+func filterSensitiveDataInDump(value interface{}, sensitiveData map[string]string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return utils.FilterSensitiveData(v, sensitiveData)
+	case map[string]interface{}:
+		filtered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			filtered[key] = filterSensitiveDataInDump(item, sensitiveData)
+		}
+		return filtered
+	case []map[string]interface{}:
+		filtered := make([]map[string]interface{}, len(v))
+		for i, item := range v {
+			filtered[i] = filterSensitiveDataInDump(item, sensitiveData).(map[string]interface{})
+		}
+		return filtered
+	case []interface{}:
+		filtered := make([]interface{}, len(v))
+		for i, item := range v {
+			filtered[i] = filterSensitiveDataInDump(item, sensitiveData)
+		}
+		return filtered
+	case []string:
+		filtered := make([]string, len(v))
+		for i, item := range v {
+			filtered[i] = utils.FilterSensitiveData(item, sensitiveData)
+		}
+		return filtered
+	}
+	return value
 }
 
 type AgentStepInfo struct {
@@ -354,3 +514,108 @@ type AgentStepInfo struct {
 func (asi *AgentStepInfo) IsLastStep() bool {
 	return asi.StepNumber >= asi.MaxSteps-1
 }
+
+// Options for replaying a saved history with Agent.RerunHistory
+type RerunHistoryOptions struct {
+	MaxRetries          int           `json:"max_retries"`
+	SkipFailures        bool          `json:"skip_failures"`
+	DelayBetweenActions time.Duration `json:"delay_between_actions"`
+}
+
+func NewRerunHistoryOptions() *RerunHistoryOptions {
+	return &RerunHistoryOptions{
+		MaxRetries:          3,
+		SkipFailures:        true,
+		DelayBetweenActions: 2 * time.Second,
+	}
+}
+
+// Kind of a failed step, decides whether the step is retried after a backoff
+type AgentErrorKind string
+
+const (
+	ErrorKindRateLimit AgentErrorKind = "rate_limit"
+	ErrorKindParse     AgentErrorKind = "parse"
+	ErrorKindNetwork   AgentErrorKind = "network"
+	ErrorKindUnknown   AgentErrorKind = "unknown"
+)
+
+const (
+	validationErrorMessage = "Invalid model output format. Please follow the correct schema."
+	rateLimitErrorMessage  = "Rate limit reached. Waiting before retry."
+)
+
+// Error of a step which counts as a consecutive failure
+type AgentError struct {
+	Kind AgentErrorKind
+	Err  error
+}
+
+func (e *AgentError) Error() string {
+	return fmt.Sprintf("%s error: %s", e.Kind, e.Err)
+}
+
+func (e *AgentError) Unwrap() error {
+	return e.Err
+}
+
+// Rate limits and network errors usually go away by waiting
+func (e *AgentError) IsTransient() bool {
+	return e.Kind == ErrorKindRateLimit || e.Kind == ErrorKindNetwork
+}
+
+// Message fed back to the model in the result of the step
+func (e *AgentError) Message() string {
+	switch e.Kind {
+	case ErrorKindParse:
+		return fmt.Sprintf("%s\nDetails: %s", validationErrorMessage, e.Err)
+	case ErrorKindRateLimit:
+		return rateLimitErrorMessage
+	}
+	return e.Err.Error()
+}
+
+func newParseError(err error) *AgentError {
+	return &AgentError{Kind: ErrorKindParse, Err: err}
+}
+
+// e.g. "error, status code: 429, status: 429 Too Many Requests, message: ..." of the openai client
+var statusCodePattern = regexp.MustCompile(`status code: (\d{3})`)
+
+// Classify an error of the llm or of an action, errors which are already classified are returned as is
+func classifyError(err error) *AgentError {
+	var agentErr *AgentError
+	if errors.As(err, &agentErr) {
+		return agentErr
+	}
+
+	msg := strings.ToLower(err.Error())
+	if match := statusCodePattern.FindStringSubmatch(msg); match != nil {
+		switch code := match[1]; {
+		case code == "429":
+			return &AgentError{Kind: ErrorKindRateLimit, Err: err}
+		case code == "408" || code[0] == '5':
+			return &AgentError{Kind: ErrorKindNetwork, Err: err}
+		}
+	}
+	for _, s := range []string{"rate limit", "rate_limit", "too many requests", "resource exhausted", "resource_exhausted"} {
+		if strings.Contains(msg, s) {
+			return &AgentError{Kind: ErrorKindRateLimit, Err: err}
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return &AgentError{Kind: ErrorKindNetwork, Err: err}
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return newParseError(err)
+	}
+	return &AgentError{Kind: ErrorKindUnknown, Err: err}
+}
@@ -1,23 +1,42 @@
 package browser
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/nerdface-ai/browser-use-go/internals/dom"
+	"github.com/playwright-community/playwright-go"
 )
 
+func newTestBrowser(t *testing.T) *Browser {
+	config := NewBrowserConfig()
+	config.Headless = true
+	browser, err := NewBrowser(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return browser
+}
+
 func TestNewBrowser(t *testing.T) {
-	browser := NewBrowser(BrowserConfig{
-		"headless": true,
-	})
+	browser := newTestBrowser(t)
 	defer browser.Close()
 	bc := browser.NewContext()
 	defer bc.Close()
 
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
 	t.Log(page.URL())
 	if page.URL() != "about:blank" {
 		t.Errorf("Expected URL to be about:blank, got %s", page.URL())
@@ -25,9 +44,7 @@ func TestNewBrowser(t *testing.T) {
 }
 
 func TestScreenshot(t *testing.T) {
-	browser := NewBrowser(BrowserConfig{
-		"headless": true,
-	})
+	browser := newTestBrowser(t)
 	defer browser.Close()
 	bc := browser.NewContext()
 	defer bc.Close()
@@ -42,14 +59,16 @@ func TestScreenshot(t *testing.T) {
 }
 
 func TestGetScrollInfo(t *testing.T) {
-	browser := NewBrowser(BrowserConfig{
-		"headless": true,
-	})
+	browser := newTestBrowser(t)
 	defer browser.Close()
 	bc := browser.NewContext()
 	defer bc.Close()
 
-	pixelsAbove, pixelsBelow, err := bc.GetScrollInfo(bc.GetCurrentPage())
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pixelsAbove, pixelsBelow, err := bc.GetScrollInfo(page)
 	if err != nil {
 		t.Error(err)
 	}
@@ -61,35 +80,128 @@ func TestGetScrollInfo(t *testing.T) {
 }
 
 func TestNavigateTo(t *testing.T) {
-	browser := NewBrowser(BrowserConfig{
-		"headless": true,
-	})
+	browser := newTestBrowser(t)
 	defer browser.Close()
 	bc := browser.NewContext()
 	defer bc.Close()
 
 	bc.NavigateTo("https://www.google.com")
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
 	t.Log(page.URL())
 	if !strings.HasPrefix(page.URL(), "https://www.google.com") {
 		t.Errorf("Expected URL to be https://www.google.com, got %s", page.URL())
 	}
 }
 
-func TestClickElementNode(t *testing.T) {
-	browser := NewBrowser(BrowserConfig{
-		"headless": true,
+func TestPopupToDisallowedDomain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><body>popup test</body></html>")
+	}))
+	defer server.Close()
+
+	browser := newTestBrowser(t)
+	defer browser.Close()
+	config := NewBrowserContextConfig()
+	config.AllowedDomains = []string{"127.0.0.1"}
+	bc, err := browser.NewContextWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	if err := bc.NavigateTo(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same server under a host which is not allowed
+	disallowedUrl := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	popup, err := page.ExpectPopup(func() error {
+		_, err := page.Evaluate(fmt.Sprintf("window.open(%q)", disallowedUrl))
+		return err
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for popup.URL() != "about:blank" && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if popup.URL() != "about:blank" {
+		t.Errorf("expected the popup to be sent to about:blank, got %s", popup.URL())
+	}
+}
+
+func TestPerformClickDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/report.txt" {
+			w.Header().Set("Content-Disposition", `attachment; filename="report.txt"`)
+			fmt.Fprint(w, "report")
+			return
+		}
+		fmt.Fprint(w, `<html><body><a id="download" href="/report.txt">report</a><button id="noop">noop</button></body></html>`)
+	}))
+	defer server.Close()
+
+	browser := newTestBrowser(t)
+	defer browser.Close()
+	config := NewBrowserContextConfig()
+	config.SaveDownloadsPath = t.TempDir()
+	bc, err := browser.NewContextWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	if err := bc.NavigateTo(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if path := bc.PerformClick(func() { page.Click("#noop") }, page); path != nil {
+		t.Errorf("expected no download for a regular click, got %s", *path)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected a regular click not to wait for a download, took %s", elapsed)
+	}
+
+	path := bc.PerformClick(func() { page.Click("#download") }, page)
+	if path == nil {
+		t.Fatal("expected the download to be saved")
+	}
+	if content, err := os.ReadFile(*path); err != nil || string(content) != "report" {
+		t.Errorf("expected the downloaded file, got %q, %v", content, err)
+	}
+}
+
+func TestClickElementNode(t *testing.T) {
+	browser := newTestBrowser(t)
 	defer browser.Close()
 	bc := browser.NewContext()
 	defer bc.Close()
 
 	bc.NavigateTo("https://example.com")
 
-	currentState := bc.GetState(false)
+	currentState, err := bc.GetState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
 	time.Sleep(1 * time.Second)
 
-	session := bc.GetSession()
+	session, err := bc.GetSession()
+	if err != nil {
+		t.Fatal(err)
+	}
 	session.CachedState = currentState
 
 	processor := &dom.ClickableElementProcessor{}
@@ -107,9 +219,7 @@ func TestClickElementNode(t *testing.T) {
 }
 
 func TestInputTextElementNode(t *testing.T) {
-	browser := NewBrowser(BrowserConfig{
-		"headless": true,
-	})
+	browser := newTestBrowser(t)
 	defer browser.Close()
 	bc := browser.NewContext()
 	defer bc.Close()
@@ -117,10 +227,16 @@ func TestInputTextElementNode(t *testing.T) {
 	bc.NavigateTo("https://www.google.com")
 
 	// ------- test -------
-	currentState := bc.GetState(false)
+	currentState, err := bc.GetState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
 	time.Sleep(1 * time.Second)
 
-	session := bc.GetSession()
+	session, err := bc.GetSession()
+	if err != nil {
+		t.Fatal(err)
+	}
 	session.CachedState = currentState
 
 	// ------- test -------
@@ -133,9 +249,7 @@ func TestInputTextElementNode(t *testing.T) {
 func TestHighlightElements(t *testing.T) {
 	log.SetLevel(log.DebugLevel)
 
-	browser := NewBrowser(BrowserConfig{
-		"headless": true,
-	})
+	browser := newTestBrowser(t)
 	defer browser.Close()
 	bc := browser.NewContext()
 	defer bc.Close()
@@ -143,7 +257,10 @@ func TestHighlightElements(t *testing.T) {
 	// bc.NavigateTo("https://huggingface.co/")
 	bc.NavigateTo("https://example.com")
 
-	currentState := bc.GetState(true)
+	currentState, err := bc.GetState(true)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	elementStr := currentState.ElementTree.ClickableElementsToString([]string{})
 
@@ -156,3 +273,444 @@ This domain is for use in illustrative examples in documents. You may use this
 		t.Error("expected", expected, "got", elementStr)
 	}
 }
+
+func TestIsUrlAllowed(t *testing.T) {
+	bc := &BrowserContext{Config: BrowserContextConfig{
+		AllowedDomains: []string{"example.com", "*.google.com", "http://localhost:8080"},
+		BlockedDomains: []string{"ads.example.com"},
+	}}
+
+	cases := map[string]bool{
+		"about:blank":                    true,
+		"chrome://new-tab-page/":         true,
+		"https://example.com/path":       true,
+		"http://www.example.com":         false,
+		"https://ads.example.com/banner": false,
+		"https://google.com":             true,
+		"https://mail.google.com":        true,
+		"https://google.com.evil.com":    false,
+		"https://evil.com/example.com":   false,
+		"ftp://example.com":              false,
+		"http://localhost:3000":          true,
+		"https://localhost":              false,
+		"file:///etc/passwd":             false,
+	}
+	for url, expected := range cases {
+		if got := bc.isUrlAllowed(url); got != expected {
+			t.Errorf("isUrlAllowed(%q) = %v, expected %v", url, got, expected)
+		}
+	}
+
+	unrestricted := &BrowserContext{Config: NewBrowserContextConfig()}
+	if !unrestricted.isUrlAllowed("https://anything.example") {
+		t.Error("expected every url to be allowed without allowed_domains")
+	}
+}
+
+func TestRequestBlocking(t *testing.T) {
+	bc := &BrowserContext{Config: BrowserContextConfig{
+		BlockAds:             true,
+		BlockedResourceTypes: []string{"image", "font", "document"},
+		BlockedUrlPatterns:   []string{"*/analytics/*"},
+		AllowedDomains:       []string{"example.com"},
+	}}
+	policy := newRequestPolicy(bc.Config)
+	if policy.isEmpty() {
+		t.Fatal("expected a request policy")
+	}
+
+	cases := []struct {
+		url          string
+		resourceType string
+		expected     string
+	}{
+		{"https://example.com/logo.png", "image", "resource type"},
+		{"https://example.com/", "document", ""},
+		{"https://stats.g.doubleclick.net/collect", "script", "domain"},
+		{"https://example.com/analytics/track.js", "script", "url pattern"},
+		{"https://api.other.com/data", "fetch", "allowed domains"},
+		{"https://cdn.other.com/app.js", "script", ""},
+		{"https://example.com/api", "xhr", ""},
+	}
+	for _, c := range cases {
+		if got := bc.blockReason(policy, c.url, c.resourceType); got != c.expected {
+			t.Errorf("blockReason(%q, %q) = %q, expected %q", c.url, c.resourceType, got, c.expected)
+		}
+	}
+
+	bc.countBlockedRequest("domain")
+	bc.countBlockedRequest("domain")
+	bc.countBlockedRequest("resource type")
+	summary := bc.popBlockedRequests()
+	if len(summary) != 2 || summary[0] != "Blocked 2 requests by domain" || summary[1] != "Blocked 1 requests by resource type" {
+		t.Errorf("unexpected blocked requests summary: %v", summary)
+	}
+	if summary := bc.popBlockedRequests(); len(summary) != 0 {
+		t.Errorf("expected counts to be reset, got %v", summary)
+	}
+
+	if !newRequestPolicy(NewBrowserContextConfig()).isEmpty() {
+		t.Error("expected no request policy without config")
+	}
+}
+
+func TestUserProvidedBrowserArgs(t *testing.T) {
+	config := NewBrowserConfig()
+	config.BrowserBinaryPath = "/usr/bin/google-chrome"
+	config.Headless = true
+	config.ExtraBrowserArgs = []string{"--lang=en-US"}
+	browser := &Browser{Config: config}
+	args := strings.Join(browser.userProvidedBrowserArgs(9333, "/tmp/profile"), " ")
+	for _, expected := range []string{"--user-data-dir=/tmp/profile", "--profile-directory=Default", "--remote-debugging-port=9333", "--headless=new", "--lang=en-US"} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("expected %s in args: %s", expected, args)
+		}
+	}
+	if strings.Contains(args, "--remote-debugging-port=9222") {
+		t.Errorf("expected default debug port to be replaced: %s", args)
+	}
+	if !browser.isAttached() {
+		t.Error("expected a user provided browser to be attached over CDP")
+	}
+}
+
+func TestBuiltinBrowserArgs(t *testing.T) {
+	config := NewBrowserConfig()
+	config.Headless = true
+	config.BrowserClass = BrowserClassFirefox
+	config.ExtraBrowserArgs = []string{"-private"}
+	firefox := &Browser{Config: config}
+	if args := firefox.builtinBrowserArgs(); !slices.Equal(args, []string{"-no-remote", "-private"}) {
+		t.Errorf("expected firefox args without chrome args, got %v", args)
+	}
+	config = NewBrowserConfig()
+	config.Headless = true
+	config.BrowserClass = "WebKit"
+	webkit := &Browser{Config: config}
+	if args := webkit.builtinBrowserArgs(); !slices.Equal(args, []string{"--no-startup-window"}) {
+		t.Errorf("expected webkit args without chrome args, got %v", args)
+	}
+	if webkit.isChromium() {
+		t.Error("expected webkit not to support CDP")
+	}
+
+	config = NewBrowserConfig()
+	config.Headless = true
+	config.BrowserClass = ""
+	chromium := &Browser{Config: config}
+	if !chromium.isChromium() {
+		t.Error("expected chromium by default")
+	}
+	args := strings.Join(chromium.builtinBrowserArgs(), " ")
+	for _, expected := range []string{"--headless=new", "--window-size=1920,1080"} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("expected %s in args: %s", expected, args)
+		}
+	}
+}
+
+func TestProxySettings(t *testing.T) {
+	config := NewBrowserConfig()
+	config.Proxy = &ProxySettings{Server: "http://proxy.example.com:3128", Username: "user", Password: "secret"}
+	browser, err := NewBrowser(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwProxy := browser.Config.Proxy.toPlaywright()
+	if pwProxy.Server != "http://proxy.example.com:3128" || *pwProxy.Username != "user" || *pwProxy.Password != "secret" || pwProxy.Bypass != nil {
+		t.Errorf("unexpected proxy %+v", pwProxy)
+	}
+	if proxy := browser.NewContext().proxy(); proxy.Server != "http://proxy.example.com:3128" {
+		t.Errorf("expected contexts to use the browser proxy, got %s", proxy.Server)
+	}
+
+	// a context can use its own proxy
+	contextConfig := NewBrowserContextConfig()
+	contextConfig.Proxy = &ProxySettings{Server: "socks5://other.example.com:1080"}
+	bc, err := browser.NewContextWithConfig(contextConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy := bc.proxy(); proxy.Server != "socks5://other.example.com:1080" {
+		t.Errorf("expected context proxy, got %s", proxy.Server)
+	}
+	if (*ProxySettings)(nil).toPlaywright() != nil {
+		t.Error("expected no playwright proxy without settings")
+	}
+
+	var browserErr *BrowserError
+	contextConfig.Proxy = &ProxySettings{Username: "user"}
+	if _, err := browser.NewContextWithConfig(contextConfig); !errors.As(err, &browserErr) {
+		t.Errorf("expected a BrowserError for a proxy without server, got %v", err)
+	}
+
+	// rejected credentials are reported as a BrowserError
+	err = bc.checkProxyAuth(nil, errors.New("page.goto: net::ERR_PROXY_AUTH_UNSUPPORTED at https://example.com"))
+	if !errors.As(err, &browserErr) || !strings.Contains(browserErr.Message, "socks5://other.example.com:1080") {
+		t.Errorf("expected a proxy BrowserError, got %v", err)
+	}
+	other := errors.New("page.goto: net::ERR_NAME_NOT_RESOLVED")
+	if err := bc.checkProxyAuth(nil, other); err != other {
+		t.Errorf("expected other errors to be returned unchanged, got %v", err)
+	}
+}
+
+func TestBrowserConfigValidate(t *testing.T) {
+	config := NewBrowserConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("expected the default config to be valid, got %v", err)
+	}
+
+	config.BrowserClass = "firefox"
+	config.CdpUrl = "http://localhost:9222"
+	config.WssUrl = "ws://localhost:3000"
+	config.NewContextConfig.MaximumWaitPageLoadTime = 0.1
+	config.NewContextConfig.BlockedResourceTypes = []string{"images"}
+	_, err := NewBrowser(config)
+	var browserErr *BrowserError
+	if !errors.As(err, &browserErr) {
+		t.Fatalf("expected a BrowserError, got %v", err)
+	}
+	for _, expected := range []string{"only one of cdp_url", "require browser_class chromium", "maximum_wait_page_load_time", `"images"`} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %q", expected, err)
+		}
+	}
+}
+
+func TestBrowserConfigFromMap(t *testing.T) {
+	config, err := BrowserConfigFromMap(map[string]interface{}{
+		"headless":            true,
+		"disable_security":    true,
+		"allowed_domains":     []string{"example.com"},
+		"browser_window_size": map[string]int{"width": 800, "height": 600},
+		"proxy":               "http://proxy.example.com:3128",
+		"connect_timeout":     5000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Headless || !config.DisableSecurity || config.ConnectTimeout != 5000 || config.Proxy.Server != "http://proxy.example.com:3128" {
+		t.Errorf("unexpected browser config %+v", config)
+	}
+	contextConfig := config.NewContextConfig
+	if !contextConfig.DisableSecurity || !slices.Equal(contextConfig.AllowedDomains, []string{"example.com"}) || contextConfig.BrowserWindowSize.Width != 800 {
+		t.Errorf("unexpected context config %+v", contextConfig)
+	}
+	if contextConfig.MinimumWaitPageLoadTime != 0.25 || !contextConfig.HighlightElements {
+		t.Errorf("expected defaults for missing keys, got %+v", contextConfig)
+	}
+
+	var browserErr *BrowserError
+	for _, invalid := range []map[string]interface{}{{"headles": true}, {"headless": "yes"}, {"browser_class": "netscape"}} {
+		if _, err := NewBrowserFromMap(invalid); !errors.As(err, &browserErr) {
+			t.Errorf("expected a BrowserError for %v, got %v", invalid, err)
+		}
+	}
+}
+
+func TestLoadBrowserConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"browser.json": `{"headless": true, "browser_class": "firefox", "new_context_config": {"allowed_domains": ["example.com"], "proxy": {"server": "http://proxy:3128"}}}`,
+		"browser.yaml": "headless: true\nbrowser_class: firefox\nnew_context_config:\n  allowed_domains:\n    - example.com\n  proxy:\n    server: http://proxy:3128\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadBrowserConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !config.Headless || config.BrowserClass != BrowserClassFirefox || config.ReconnectAttempts != 3 {
+			t.Errorf("%s: unexpected browser config %+v", name, config)
+		}
+		contextConfig := config.NewContextConfig
+		if !slices.Equal(contextConfig.AllowedDomains, []string{"example.com"}) || contextConfig.Proxy.Server != "http://proxy:3128" || contextConfig.MaximumWaitPageLoadTime != 5 {
+			t.Errorf("%s: unexpected context config %+v", name, contextConfig)
+		}
+	}
+
+	invalid := filepath.Join(dir, "invalid.yml")
+	if err := os.WriteFile(invalid, []byte("headless: true\nnew_context_config:\n  highlight: false\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBrowserConfig(invalid); err == nil || !strings.Contains(err.Error(), "highlight") {
+		t.Errorf("expected unknown keys to be reported, got %v", err)
+	}
+}
+
+func TestGetUniqueFilename(t *testing.T) {
+	dir := t.TempDir()
+	if got := getUniqueFilename(dir, "invoice.pdf"); got != "invoice.pdf" {
+		t.Errorf("expected invoice.pdf, got %s", got)
+	}
+	for _, name := range []string{"invoice.pdf", "invoice (1).pdf"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := getUniqueFilename(dir, "invoice.pdf"); got != "invoice (2).pdf" {
+		t.Errorf("expected invoice (2).pdf, got %s", got)
+	}
+	if got := getUniqueFilename(dir, "../../etc/passwd"); got != "passwd" {
+		t.Errorf("expected path elements to be dropped, got %s", got)
+	}
+}
+
+func TestLoadStorageState(t *testing.T) {
+	dir := t.TempDir()
+	cookiesFile := filepath.Join(dir, "cookies.json")
+	if err := writeFileAtomic(cookiesFile, []byte(`[{"name": "sid", "value": "1", "domain": "example.com", "path": "/"}]`)); err != nil {
+		t.Fatal(err)
+	}
+	bc := &BrowserContext{Config: BrowserContextConfig{CookiesFile: cookiesFile}}
+	state, err := bc.loadStorageState()
+	if err != nil || state == nil || len(state.Cookies) != 1 || state.Cookies[0].Name != "sid" {
+		t.Fatalf("expected cookie list to be loaded, got %+v, %v", state, err)
+	}
+
+	storageStatePath := filepath.Join(dir, "state", "storage.json")
+	if err := writeFileAtomic(storageStatePath, []byte(`{"cookies": [{"name": "token", "value": "2", "domain": "example.com", "path": "/"}], "origins": [{"origin": "https://example.com", "localStorage": [{"name": "k", "value": "v"}]}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	bc = &BrowserContext{Config: BrowserContextConfig{CookiesFile: cookiesFile, StorageStatePath: storageStatePath}}
+	state, err = bc.loadStorageState()
+	if err != nil || state == nil || len(state.Cookies) != 1 || state.Cookies[0].Name != "token" || len(state.Origins) != 1 {
+		t.Fatalf("expected storage state to be loaded, got %+v, %v", state, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(storageStatePath))
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left, got %d entries", len(entries))
+	}
+
+	bc = &BrowserContext{Config: BrowserContextConfig{StorageStatePath: filepath.Join(dir, "missing.json")}}
+	if state, err := bc.loadStorageState(); state != nil || err != nil {
+		t.Errorf("expected missing file to be ignored, got %+v, %v", state, err)
+	}
+}
+
+func TestNetworkTrackerFilters(t *testing.T) {
+	tracker := newNetworkTracker([]string{"/slow-widget/"})
+	requests := []struct {
+		url          string
+		resourceType string
+		headers      map[string]string
+		expected     bool
+	}{
+		{"https://example.com/", "document", nil, true},
+		{"https://example.com/app.js", "script", nil, true},
+		{"https://example.com/api/items", "xhr", nil, false},
+		{"https://www.google-analytics.com/collect", "image", nil, false},
+		{"wss://example.com/socket", "websocket", nil, false},
+		{"data:image/png;base64,AAAA", "image", nil, false},
+		{"https://example.com/slow-widget/embed.js", "script", nil, false},
+		{"https://example.com/next.html", "document", map[string]string{"purpose": "prefetch"}, false},
+	}
+	for _, r := range requests {
+		if got := tracker.isRelevantRequest(r.url, r.resourceType, r.headers); got != r.expected {
+			t.Errorf("isRelevantRequest(%q, %q) = %v, expected %v", r.url, r.resourceType, got, r.expected)
+		}
+	}
+
+	responses := map[string]bool{
+		"text/html; charset=utf-8": true,
+		"image/png":                true,
+		"text/event-stream":        false,
+		"video/mp4":                false,
+		"application/octet-stream": false,
+	}
+	for contentType, expected := range responses {
+		if got := isRelevantResponse(map[string]string{"content-type": contentType}); got != expected {
+			t.Errorf("isRelevantResponse(%q) = %v, expected %v", contentType, got, expected)
+		}
+	}
+}
+
+func TestRecordingOptions(t *testing.T) {
+	dir := t.TempDir()
+	bc := &BrowserContext{ContextId: "ctx-1", Config: BrowserContextConfig{
+		SaveRecordingPath: filepath.Join(dir, "videos"),
+		SaveHarPath:       filepath.Join(dir, "har"),
+		BrowserWindowSize: WindowSize{Width: 800, Height: 600},
+	}}
+	video := bc.recordVideoOptions()
+	if video == nil || video.Size.Width != 800 || video.Size.Height != 600 {
+		t.Errorf("expected video size from window size, got %+v", video)
+	}
+	harPath := bc.recordHarPath()
+	if harPath == nil || *harPath != filepath.Join(dir, "har", "ctx-1.har") || bc.HarPath != *harPath {
+		t.Errorf("expected HAR path based on context id, got %v", harPath)
+	}
+
+	unrecorded := &BrowserContext{ContextId: "ctx-2", Config: BrowserContextConfig{}}
+	if unrecorded.recordVideoOptions() != nil || unrecorded.recordHarPath() != nil {
+		t.Error("expected no recording without config")
+	}
+	if width, height := unrecorded.windowSize(); width != 1280 || height != 1100 {
+		t.Errorf("expected default window size, got %dx%d", width, height)
+	}
+}
+
+func TestBrowserErrorTypes(t *testing.T) {
+	cause := errors.New("connection refused")
+	launchErr := NewBrowserLaunchError(cause)
+	var launch *BrowserLaunchError
+	if !errors.As(launchErr, &launch) || !errors.Is(launchErr, cause) {
+		t.Errorf("expected a BrowserLaunchError wrapping the cause, got %v", launchErr)
+	}
+
+	closedErr := sessionError(fmt.Errorf("new page: %w", playwright.ErrTargetClosed))
+	var closed *SessionClosedError
+	if !errors.As(closedErr, &closed) || !errors.Is(closedErr, playwright.ErrTargetClosed) {
+		t.Errorf("expected a SessionClosedError, got %v", closedErr)
+	}
+	if err := sessionError(cause); err != cause {
+		t.Errorf("expected other errors to be returned as is, got %v", err)
+	}
+
+	jsErr := NewJSEvaluationError(cause)
+	var js *JSEvaluationError
+	if !errors.As(jsErr, &js) || js.Message != "JavaScript evaluation failed: connection refused" {
+		t.Errorf("expected a JSEvaluationError, got %v", jsErr)
+	}
+
+	// the selector map of a context without a session is empty, it does not start the browser
+	bc := &BrowserContext{Config: NewBrowserContextConfig()}
+	if bc.GetSelectorMap() != nil {
+		t.Error("expected no selector map without a session")
+	}
+	if _, err := bc.GetDomElementByIndex(0); err == nil {
+		t.Error("expected an error for an element without a selector map")
+	}
+}
+
+func TestReconnectWithoutAttempts(t *testing.T) {
+	config := NewBrowserConfig()
+	config.CdpUrl = "http://localhost:9222"
+	config.ReconnectAttempts = 0
+	// an invalid config fails every attempt before anything is started
+	config.ConnectTimeout = -1
+	b := &Browser{Config: config, Playwright: &playwright.Playwright{}}
+
+	start := time.Now()
+	browser, err := b.reconnect()
+	if err == nil || browser != nil {
+		t.Fatalf("expected the single attempt to fail, got %v %v", browser, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("expected no backoff after the last attempt, took %s", time.Since(start))
+	}
+}
+
+func TestCloseWithoutDriver(t *testing.T) {
+	b := &Browser{Config: NewBrowserConfig()}
+	if err := b.Close(); err != nil {
+		t.Errorf("expected closing an unlaunched browser to succeed, got %v", err)
+	}
+	if _, err := b.reconnect(); err == nil {
+		t.Error("expected reconnecting without a driver to fail")
+	}
+}
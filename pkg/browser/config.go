@@ -0,0 +1,275 @@
+package browser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration of the browser process. Start from NewBrowserConfig, which sets the defaults,
+// or load it with LoadBrowserConfig. Configs in the old map form can be converted with BrowserConfigFromMap.
+type BrowserConfig struct {
+	Headless               bool     `json:"headless"`
+	DisableSecurity        bool     `json:"disable_security"`
+	BrowserClass           string   `json:"browser_class"` // chromium, firefox or webkit
+	DeterministicRendering bool     `json:"deterministic_rendering"`
+	ExtraBrowserArgs       []string `json:"extra_browser_args,omitempty"`
+
+	// Attach to a running browser instead of launching one, at most one of these can be set
+	CdpUrl            string `json:"cdp_url,omitempty"`
+	WssUrl            string `json:"wss_url,omitempty"`
+	BrowserBinaryPath string `json:"browser_binary_path,omitempty"`
+
+	// Profile and debug port of the browser started from BrowserBinaryPath
+	BrowserUserDataDir      string `json:"browser_user_data_dir,omitempty"` // defaults to <xdg config>/browseruse/chrome_profile
+	BrowserProfileDirectory string `json:"browser_profile_directory"`
+	BrowserDebugPort        int    `json:"browser_debug_port"`
+
+	ConnectTimeout    float64           `json:"connect_timeout"` // in milliseconds
+	ConnectHeaders    map[string]string `json:"connect_headers,omitempty"`
+	ReconnectAttempts int               `json:"reconnect_attempts"`
+
+	Proxy *ProxySettings `json:"proxy,omitempty"`
+
+	// Config of the contexts created with Browser.NewContext
+	NewContextConfig BrowserContextConfig `json:"new_context_config"`
+}
+
+// Configuration of a browser context. Start from NewBrowserContextConfig, which sets the defaults.
+type BrowserContextConfig struct {
+	// Page load, in seconds
+	MinimumWaitPageLoadTime        float64  `json:"minimum_wait_page_load_time"`
+	WaitForNetworkIdlePageLoadTime float64  `json:"wait_for_network_idle_page_load_time"`
+	MaximumWaitPageLoadTime        float64  `json:"maximum_wait_page_load_time"`
+	IgnoredNetworkUrlPatterns      []string `json:"ignored_network_url_patterns,omitempty"`
+
+	// Playwright context options
+	BrowserWindowSize WindowSize                  `json:"browser_window_size"`
+	DisableSecurity   bool                        `json:"disable_security"`
+	UserAgent         string                      `json:"user_agent,omitempty"`
+	Locale            string                      `json:"locale,omitempty"`
+	TimezoneId        string                      `json:"timezone_id,omitempty"`
+	IsMobile          bool                        `json:"is_mobile"`
+	HasTouch          bool                        `json:"has_touch"`
+	HttpCredentials   *playwright.HttpCredentials `json:"http_credentials,omitempty"`
+
+	// DOM extraction
+	HighlightElements        bool `json:"highlight_elements"`
+	ViewportExpansion        int  `json:"viewport_expansion"`
+	IncludeDynamicAttributes bool `json:"include_dynamic_attributes"`
+
+	// Domain patterns, see utils.MatchUrlWithDomainPattern
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	BlockedDomains []string `json:"blocked_domains,omitempty"`
+
+	// Request blocking, see requestPolicy
+	BlockAds              bool     `json:"block_ads"`
+	BlockedRequestDomains []string `json:"blocked_request_domains,omitempty"`
+	BlockedResourceTypes  []string `json:"blocked_resource_types,omitempty"`
+	BlockedUrlPatterns    []string `json:"blocked_url_patterns,omitempty"`
+
+	// Files written by the context
+	SaveDownloadsPath      string `json:"save_downloads_path,omitempty"`
+	SaveRecordingPath      string `json:"save_recording_path,omitempty"`
+	SaveHarPath            string `json:"save_har_path,omitempty"`
+	StorageStatePath       string `json:"storage_state_path,omitempty"`
+	CookiesFile            string `json:"cookies_file,omitempty"`
+	SaveStorageStateOnStep bool   `json:"save_storage_state_on_step"`
+
+	// Leave the playwright context open when the BrowserContext is closed
+	KeepAlive bool `json:"keep_alive"`
+
+	// Overrides the proxy of the browser
+	Proxy *ProxySettings `json:"proxy,omitempty"`
+}
+
+type WindowSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func NewBrowserConfig() BrowserConfig {
+	return BrowserConfig{
+		Headless:                false,
+		DisableSecurity:         false,
+		BrowserClass:            BrowserClassChromium,
+		BrowserProfileDirectory: CHROME_PROFILE_USER,
+		BrowserDebugPort:        CHROME_DEBUG_PORT,
+		ConnectTimeout:          30000,
+		ReconnectAttempts:       3,
+		NewContextConfig:        NewBrowserContextConfig(),
+	}
+}
+
+func NewBrowserContextConfig() BrowserContextConfig {
+	return BrowserContextConfig{
+		MinimumWaitPageLoadTime:        0.25,
+		WaitForNetworkIdlePageLoadTime: 0.5,
+		MaximumWaitPageLoadTime:        5,
+		BrowserWindowSize:              WindowSize{Width: 1280, Height: 1100},
+		HighlightElements:              true,
+		ViewportExpansion:              0,
+		IncludeDynamicAttributes:       true,
+	}
+}
+
+// Resource types reported by playwright
+var resourceTypes = []string{
+	"document", "stylesheet", "image", "media", "font", "script", "texttrack",
+	"xhr", "fetch", "eventsource", "websocket", "manifest", "other",
+}
+
+// Validate reports every invalid setting of the config, including its NewContextConfig
+func (c *BrowserConfig) Validate() error {
+	errs := []error{}
+	invalid := func(format string, args ...any) {
+		errs = append(errs, &BrowserError{Message: fmt.Sprintf(format, args...)})
+	}
+
+	class := strings.ToLower(c.BrowserClass)
+	if class != "" && !slices.Contains([]string{BrowserClassChromium, BrowserClassFirefox, BrowserClassWebkit}, class) {
+		invalid("browser_class should be chromium, firefox or webkit, got %q", c.BrowserClass)
+	}
+	attached := 0
+	for _, value := range []string{c.CdpUrl, c.WssUrl, c.BrowserBinaryPath} {
+		if value != "" {
+			attached++
+		}
+	}
+	if attached > 1 {
+		invalid("only one of cdp_url, wss_url and browser_binary_path can be set")
+	}
+	// CDP is only spoken by chromium, firefox and webkit are launched by playwright or served over WebSocket
+	if class != "" && class != BrowserClassChromium && (c.CdpUrl != "" || c.BrowserBinaryPath != "") {
+		invalid("cdp_url and browser_binary_path require browser_class chromium, got %s", c.BrowserClass)
+	}
+	if c.BrowserDebugPort < 0 || c.BrowserDebugPort > 65535 {
+		invalid("browser_debug_port should be between 0 and 65535, got %d", c.BrowserDebugPort)
+	}
+	if c.ConnectTimeout < 0 {
+		invalid("connect_timeout should not be negative, got %v", c.ConnectTimeout)
+	}
+	if c.ReconnectAttempts < 0 {
+		invalid("reconnect_attempts should not be negative, got %d", c.ReconnectAttempts)
+	}
+	if c.Proxy != nil {
+		if err := c.Proxy.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := c.NewContextConfig.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting of the context config
+func (c *BrowserContextConfig) Validate() error {
+	errs := []error{}
+	invalid := func(format string, args ...any) {
+		errs = append(errs, &BrowserError{Message: fmt.Sprintf(format, args...)})
+	}
+
+	for name, value := range map[string]float64{
+		"minimum_wait_page_load_time":          c.MinimumWaitPageLoadTime,
+		"wait_for_network_idle_page_load_time": c.WaitForNetworkIdlePageLoadTime,
+		"maximum_wait_page_load_time":          c.MaximumWaitPageLoadTime,
+	} {
+		if value < 0 {
+			invalid("%s should not be negative, got %v", name, value)
+		}
+	}
+	if c.MaximumWaitPageLoadTime < c.MinimumWaitPageLoadTime {
+		invalid("maximum_wait_page_load_time (%v) should not be less than minimum_wait_page_load_time (%v)", c.MaximumWaitPageLoadTime, c.MinimumWaitPageLoadTime)
+	}
+	if c.BrowserWindowSize.Width < 0 || c.BrowserWindowSize.Height < 0 {
+		invalid("browser_window_size should not be negative, got %dx%d", c.BrowserWindowSize.Width, c.BrowserWindowSize.Height)
+	}
+	if c.ViewportExpansion < -1 {
+		invalid("viewport_expansion should be -1 (whole page) or more, got %d", c.ViewportExpansion)
+	}
+	for _, resourceType := range c.BlockedResourceTypes {
+		if !slices.Contains(resourceTypes, resourceType) {
+			invalid("blocked_resource_types contains unknown resource type %q", resourceType)
+		}
+	}
+	if c.Proxy != nil {
+		if err := c.Proxy.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Load a BrowserConfig from a JSON or YAML file, settings missing from the file keep their defaults
+func LoadBrowserConfig(path string) (BrowserConfig, error) {
+	config := NewBrowserConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// decode through the json tags, so both formats use the same keys
+		var values map[string]interface{}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return config, &BrowserError{Message: fmt.Sprintf("Failed to parse %s: %s", path, err)}
+		}
+		if data, err = json.Marshal(values); err != nil {
+			return config, err
+		}
+	case ".json":
+	default:
+		return config, &BrowserError{Message: fmt.Sprintf("Unsupported config file %s, use .json, .yaml or .yml", path)}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, &BrowserError{Message: fmt.Sprintf("Failed to parse %s: %s", path, err)}
+	}
+	return config, config.Validate()
+}
+
+// Convert a config in the old map form, e.g. {"headless": true, "allowed_domains": [...]}, where browser and
+// context settings share one map. Unknown keys and values of the wrong type are reported as errors.
+func BrowserConfigFromMap(customConfig map[string]interface{}) (BrowserConfig, error) {
+	config := NewBrowserConfig()
+	known := append(jsonFieldNames(reflect.TypeOf(config)), jsonFieldNames(reflect.TypeOf(config.NewContextConfig))...)
+	for key := range customConfig {
+		if !slices.Contains(known, key) {
+			return config, &BrowserError{Message: fmt.Sprintf("Unknown browser config key %q", key)}
+		}
+	}
+	data, err := json.Marshal(customConfig)
+	if err != nil {
+		return config, &BrowserError{Message: fmt.Sprintf("Invalid browser config: %s", err)}
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, &BrowserError{Message: fmt.Sprintf("Invalid browser config: %s", err)}
+	}
+	if err := json.Unmarshal(data, &config.NewContextConfig); err != nil {
+		return config, &BrowserError{Message: fmt.Sprintf("Invalid browser config: %s", err)}
+	}
+	// the proxy of the browser applies to all of its contexts
+	config.NewContextConfig.Proxy = nil
+	return config, config.Validate()
+}
+
+func jsonFieldNames(t reflect.Type) []string {
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+	return names
+}
@@ -1,11 +1,17 @@
 package browser
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/nerdface-ai/browser-use-go/internals/dom"
 	"github.com/nerdface-ai/browser-use-go/internals/utils"
@@ -92,12 +98,23 @@ type BrowserContextState struct {
 
 type BrowserContext struct {
 	ContextId        string
-	Config           BrowserConfig
+	Config           BrowserContextConfig
 	Browser          *Browser
 	Session          *BrowserSession
 	State            *BrowserContextState
 	ActiveTab        playwright.Page
 	pageEventHandler func(page playwright.Page)
+
+	// Paths of the files downloaded in this context
+	DownloadedFiles []string
+	// Paths of the page videos, set once the context is closed
+	RecordingPaths []string
+	// Path of the HAR file, complete once the context is closed
+	HarPath string
+	videos  []playwright.Video
+
+	blockedRequestsMu sync.Mutex
+	blockedRequests   map[string]int
 }
 
 func (bc *BrowserContext) ConvertSimpleXpathToCssSelector(xpath string) string {
@@ -108,17 +125,37 @@ func (bc *BrowserContext) EnhancedCssSelectorForElement(element *dom.DOMElementN
 	return dom.EnhancedCssSelectorForElement(element, includeDynamicAttributes)
 }
 
-func (bc *BrowserContext) GetState(cacheClickableElementsHashes bool) *BrowserState {
+func (bc *BrowserContext) GetState(cacheClickableElementsHashes bool) (*BrowserState, error) {
+	return bc.GetStateContext(context.Background(), cacheClickableElementsHashes)
+}
+
+// GetStateContext is GetState, the wait for the page to load is cut short when ctx is done
+func (bc *BrowserContext) GetStateContext(ctx context.Context, cacheClickableElementsHashes bool) (*BrowserState, error) {
 	/* Get the current state of the browser
 	cache_clickable_elements_hashes: bool
 		If True, cache the clickable elements hashes for the current state. This is used to calculate which elements are new to the llm (from last message) -> reduces token usage.
 	*/
 
-	bc.waitForPageAndFramesLoad(nil)
-	page := bc.GetCurrentPage()
+	if err := bc.waitForPageAndFramesLoad(ctx, nil); err != nil {
+		return nil, err
+	}
+	session, err := bc.GetSession()
+	if err != nil {
+		return nil, err
+	}
+	page, err := bc.getCurrentPage(session)
+	if err != nil {
+		return nil, err
+	}
+	navErr := bc.checkAndHandleNavigation(page)
 
-	session := bc.GetSession()
-	updatedState := bc.getUpdatedState(page)
+	updatedState, err := bc.getUpdatedState(page)
+	if err != nil {
+		return nil, err
+	}
+	if navErr != nil {
+		updatedState.BrowserErrors = append(updatedState.BrowserErrors, navErr.Error())
+	}
 
 	if cacheClickableElementsHashes {
 		clickableElementProcessor := &dom.ClickableElementProcessor{}
@@ -136,27 +173,32 @@ func (bc *BrowserContext) GetState(cacheClickableElementsHashes bool) *BrowserSt
 	}
 	session.CachedState = updatedState
 
-	// TODO(MID): Save cookies if a file is specified
-	// if bc.Config.CookiesFile != "" {
-	// 	bc.SaveCookies()
-	// }
+	if bc.Config.SaveStorageStateOnStep {
+		if err := bc.SaveStorageState(); err != nil {
+			log.Printf("🍪  Failed to save storage state: %s", err)
+		}
+	}
 
-	return updatedState
+	return updatedState, nil
 }
 
-func (bc *BrowserContext) getUpdatedState(page playwright.Page) *BrowserState {
+func (bc *BrowserContext) getUpdatedState(page playwright.Page) (*BrowserState, error) {
 	domService := dom.NewDomService(page)
+	domService.AdDomains = append(slices.Clone(dom.DefaultAdDomains), bc.Config.BlockedRequestDomains...)
 	focus_element := -1 // default
 	content, err := domService.GetClickableElements(
-		utils.GetDefaultValue(bc.Config, "highlight_elements", true),
+		bc.Config.HighlightElements,
 		focus_element,
-		utils.GetDefaultValue(bc.Config, "viewport_expansion", 0),
+		bc.Config.ViewportExpansion,
 	)
 	if err != nil {
-		log.Printf("Failed to get clickable elements: %s", err)
+		return nil, err
 	}
 
-	tabsInfo := bc.GetTabsInfo()
+	tabsInfo, err := bc.GetTabsInfo()
+	if err != nil {
+		return nil, err
+	}
 
 	screenshot, err := bc.TakeScreenshot(false)
 	if err != nil {
@@ -178,16 +220,19 @@ func (bc *BrowserContext) getUpdatedState(page playwright.Page) *BrowserState {
 		Screenshot:    screenshot,
 		PixelAbove:    pixelsAbove,
 		PixelBelow:    pixelsBelow,
-		BrowserErrors: []string{},
+		BrowserErrors: append([]string{}, bc.popBlockedRequests()...),
 	}
-	return &currentState
+	return &currentState, nil
 }
 
 // Returns a base64 encoded screenshot of the current page.
 func (bc *BrowserContext) TakeScreenshot(fullPage bool) (*string, error) {
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 
-	err := page.BringToFront()
+	err = page.BringToFront()
 	if err != nil {
 		return nil, err
 	}
@@ -208,37 +253,55 @@ func (bc *BrowserContext) TakeScreenshot(fullPage bool) (*string, error) {
 
 // Get scroll position information for the current page.
 func (bc *BrowserContext) GetScrollInfo(page playwright.Page) (int, int, error) {
-	scrollY, err := page.Evaluate("() => window.scrollY")
+	result, err := page.Evaluate(`() => ({
+		scrollY: window.scrollY,
+		viewportHeight: window.innerHeight,
+		totalHeight: document.documentElement.scrollHeight,
+	})`)
 	if err != nil {
-		return 0, 0, err
-	}
-	viewportHeight, err := page.Evaluate("() => window.innerHeight")
-	if err != nil {
-		return 0, 0, err
-	}
-	totalHeight, err := page.Evaluate("() => document.documentElement.scrollHeight")
-	if err != nil {
-		return 0, 0, err
-	}
-	pixelsAbove := scrollY.(int)
-	pixelsBelow := totalHeight.(int) - (scrollY.(int) + viewportHeight.(int))
+		return 0, 0, NewJSEvaluationError(err)
+	}
+	info, ok := result.(map[string]any)
+	if !ok {
+		return 0, 0, NewJSEvaluationError(fmt.Errorf("unexpected scroll info %v", result))
+	}
+	// scroll positions are fractional on zoomed pages, so the numbers are either int or float64
+	scrollY := int(utils.GetNumberValue(info, "scrollY", 0))
+	viewportHeight := int(utils.GetNumberValue(info, "viewportHeight", 0))
+	totalHeight := int(utils.GetNumberValue(info, "totalHeight", 0))
+	pixelsAbove := scrollY
+	pixelsBelow := totalHeight - (scrollY + viewportHeight)
 	return pixelsAbove, pixelsBelow, nil
 }
 
-func (bc *BrowserContext) GetSession() *BrowserSession {
+// Get the session of the context, it is initialized on first use
+func (bc *BrowserContext) GetSession() (*BrowserSession, error) {
+	// the session of a lost remote browser is unusable, start a new one after reconnecting
+	if bc.Session != nil && bc.Browser.isAttached() && bc.Browser.PlaywrightBrowser != nil && !bc.Browser.PlaywrightBrowser.IsConnected() {
+		log.Warn("🔌  Browser connection lost, reinitializing the browser context session")
+		bc.Session = nil
+		bc.pageEventHandler = nil
+	}
 	if bc.Session == nil {
-		session, err := bc.initializeSession()
-		if err != nil {
-			panic(err)
-		}
-		return session
+		return bc.initializeSession()
+	}
+	return bc.Session, nil
+}
+
+// Report errors caused by a closed browser or context as SessionClosedError
+func sessionError(err error) error {
+	if errors.Is(err, playwright.ErrTargetClosed) {
+		return NewSessionClosedError(err)
 	}
-	return bc.Session
+	return err
 }
 
 // Get the current page
-func (bc *BrowserContext) GetCurrentPage() playwright.Page {
-	session := bc.GetSession()
+func (bc *BrowserContext) GetCurrentPage() (playwright.Page, error) {
+	session, err := bc.GetSession()
+	if err != nil {
+		return nil, err
+	}
 	return bc.getCurrentPage(session)
 }
 
@@ -251,14 +314,16 @@ func (bc *BrowserContext) Close() {
 		bc.pageEventHandler = nil
 	}
 
-	// TODO(MID): Save cookie
-	// bc.SaveCookies()
+	if err := bc.SaveStorageState(); err != nil {
+		log.Printf("🍪  Failed to save storage state: %s", err)
+	}
 
-	if keepAlive, ok := bc.Config["keep_alive"].(bool); (ok && !keepAlive) || !ok {
+	if !bc.Config.KeepAlive {
 		err := bc.Session.Context.Close()
 		if err != nil {
 			log.Printf("🪨  Failed to close browser context: %s", err)
 		}
+		bc.saveRecordings()
 	}
 
 	// Dereference everything
@@ -267,12 +332,12 @@ func (bc *BrowserContext) Close() {
 	bc.pageEventHandler = nil
 }
 
+// Get the selector map of the last state, nil if no state has been taken yet
 func (bc *BrowserContext) GetSelectorMap() *dom.SelectorMap {
-	session := bc.GetSession()
-	if session.CachedState == nil {
+	if bc.Session == nil || bc.Session.CachedState == nil {
 		return nil
 	}
-	return session.CachedState.SelectorMap
+	return bc.Session.CachedState.SelectorMap
 }
 
 func (bc *BrowserContext) GetDomElementByIndex(index int) (*dom.DOMElementNode, error) {
@@ -314,9 +379,47 @@ func (bc *BrowserContext) IsFileUploader(element *dom.DOMElementNode, maxDepth i
 	return false
 }
 
+// Upload a file through the file input belonging to the element.
+// Elements without a file input are clicked and the opened file chooser is used instead.
+func (bc *BrowserContext) UploadFileToElementNode(elementNode *dom.DOMElementNode, path string) error {
+	if fileUploadElement := elementNode.GetFileUploadElement(true); fileUploadElement != nil {
+		locator, err := bc.GetLocateElement(fileUploadElement)
+		if err != nil {
+			return err
+		}
+		if locator == nil {
+			return &BrowserError{Message: "File input: " + fileUploadElement.Xpath + " not found"}
+		}
+		return locator.SetInputFiles(path)
+	}
+
+	// custom upload buttons open a file chooser from a hidden input
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return err
+	}
+	locator, err := bc.GetLocateElement(elementNode)
+	if err != nil {
+		return err
+	}
+	if locator == nil {
+		return &BrowserError{Message: "Element: " + elementNode.Xpath + " not found"}
+	}
+	fileChooser, err := page.ExpectFileChooser(func() error {
+		return locator.Click(playwright.LocatorClickOptions{Timeout: playwright.Float(1500)})
+	}, playwright.PageExpectFileChooserOptions{Timeout: playwright.Float(3000)})
+	if err != nil {
+		return &BrowserError{Message: "Element: " + elementNode.Xpath + " did not open a file chooser"}
+	}
+	return fileChooser.SetFiles(path)
+}
+
 // sync DOMElementNode with Playwright
-func (bc *BrowserContext) GetLocateElement(element *dom.DOMElementNode) playwright.Locator {
-	currentPage := bc.GetCurrentPage()
+func (bc *BrowserContext) GetLocateElement(element *dom.DOMElementNode) (playwright.Locator, error) {
+	currentPage, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 	var currentFrame playwright.FrameLocator = nil
 
 	// Start with the target element and collect all parents
@@ -338,7 +441,7 @@ func (bc *BrowserContext) GetLocateElement(element *dom.DOMElementNode) playwrig
 			iframes = append(iframes, item)
 		}
 	}
-	includeDynamicAttributes := utils.GetDefaultValue(bc.Config, "include_dynamic_attributes", true)
+	includeDynamicAttributes := bc.Config.IncludeDynamicAttributes
 	for _, parent := range iframes {
 		cssSelector := bc.EnhancedCssSelectorForElement(parent, includeDynamicAttributes)
 		if currentFrame != nil {
@@ -349,9 +452,9 @@ func (bc *BrowserContext) GetLocateElement(element *dom.DOMElementNode) playwrig
 	}
 	cssSelector := bc.EnhancedCssSelectorForElement(element, includeDynamicAttributes)
 	if currentFrame != nil {
-		return currentFrame.Locator(cssSelector)
+		return currentFrame.Locator(cssSelector), nil
 	} else {
-		return currentPage.Locator(cssSelector)
+		return currentPage.Locator(cssSelector), nil
 	}
 }
 
@@ -360,23 +463,43 @@ func (bc *BrowserContext) NavigateTo(url string) error {
 		return &BrowserError{Message: "Navigation to non-allowed URL: " + url}
 	}
 
-	page := bc.GetCurrentPage()
-	page.Goto(url)
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return err
+	}
+	response, err := page.Goto(url)
+	if err := bc.checkProxyAuth(response, err); err != nil {
+		// a redirect to a non-allowed URL is reported over the navigation error
+		if navErr := bc.checkAndHandleNavigation(page); navErr != nil {
+			return navErr
+		}
+		return err
+	}
 	page.WaitForLoadState()
-	return nil
+	return bc.checkAndHandleNavigation(page)
 }
 
-// TODO(HIGH): support download path in ClickElementNode
 func (bc *BrowserContext) PerformClick(clickFunc func(), page playwright.Page) *string {
 	// Performs the actual click, handling both download and navigation scenarios.
-
-	// TODO(MID): if downloadPath is specified, return downloadPath
-	// if self.config.save_downloads_path: return downloadPath
-	//
-	// }
+	// Returns the path of the downloaded file when save_downloads_path is set.
+
+	if downloadsPath := bc.Config.SaveDownloadsPath; downloadsPath != "" {
+		download := clickAndWaitForDownload(clickFunc, page)
+		if download == nil {
+			// no download was triggered, the click was a regular one
+			page.WaitForLoadState()
+			return nil
+		}
+		downloadPath, err := bc.saveDownload(download, downloadsPath)
+		if err != nil {
+			log.Errorf("💾  Failed to save download: %s", err)
+			return nil
+		}
+		return &downloadPath
+	}
 
 	// Wait for new page to open. If not, just close it
-	newPage, _ := bc.GetSession().Context.ExpectPage(func() error {
+	newPage, _ := page.Context().ExpectPage(func() error {
 		clickFunc()
 		return nil
 	}, playwright.BrowserContextExpectPageOptions{Timeout: playwright.Float(1500)})
@@ -388,11 +511,76 @@ func (bc *BrowserContext) PerformClick(clickFunc func(), page playwright.Page) *
 	return nil
 }
 
+// How long a click waits for a download to start
+const downloadStartTimeout = 1 * time.Second
+
+// Click and wait for a download to start. Gives up after downloadStartTimeout or as soon as the main frame
+// navigates, so clicks which do not download anything are not held up.
+func clickAndWaitForDownload(clickFunc func(), page playwright.Page) playwright.Download {
+	downloads := make(chan playwright.Download, 1)
+	navigated := make(chan struct{}, 1)
+	onDownload := func(download playwright.Download) {
+		select {
+		case downloads <- download:
+		default:
+		}
+	}
+	onFrameNavigated := func(frame playwright.Frame) {
+		if frame == page.MainFrame() {
+			select {
+			case navigated <- struct{}{}:
+			default:
+			}
+		}
+	}
+	page.OnDownload(onDownload)
+	page.OnFrameNavigated(onFrameNavigated)
+	defer page.RemoveListener("download", onDownload)
+	defer page.RemoveListener("framenavigated", onFrameNavigated)
+
+	clickFunc()
+	timer := time.NewTimer(downloadStartTimeout)
+	defer timer.Stop()
+	select {
+	case download := <-downloads:
+		return download
+	case <-navigated:
+	case <-timer.C:
+	}
+	// the download may have started together with the navigation
+	select {
+	case download := <-downloads:
+		return download
+	default:
+		return nil
+	}
+}
+
+// Save a download into downloadsPath under a unique filename and remember it in DownloadedFiles
+func (bc *BrowserContext) saveDownload(download playwright.Download, downloadsPath string) (string, error) {
+	if err := os.MkdirAll(downloadsPath, 0755); err != nil {
+		return "", err
+	}
+	downloadPath := filepath.Join(downloadsPath, getUniqueFilename(downloadsPath, download.SuggestedFilename()))
+	if err := download.SaveAs(downloadPath); err != nil {
+		return "", err
+	}
+	log.Infof("💾  Download saved to %s", downloadPath)
+	bc.DownloadedFiles = append(bc.DownloadedFiles, downloadPath)
+	return downloadPath, nil
+}
+
 func (bc *BrowserContext) ClickElementNode(elementNode *dom.DOMElementNode) (*string, error) {
 	// Optimized method to click an element using xpath.
-	page := bc.GetCurrentPage()
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return nil, err
+	}
 
-	elementLocator := bc.GetLocateElement(elementNode)
+	elementLocator, err := bc.GetLocateElement(elementNode)
+	if err != nil {
+		return nil, err
+	}
 	if elementLocator == nil {
 		return nil, &BrowserError{Message: "Element: " + elementNode.Xpath + " not found"}
 	}
@@ -407,8 +595,10 @@ func (bc *BrowserContext) InputTextElementNode(elementNode *dom.DOMElementNode,
 		Input text into an element with proper error handling and state management.
 		Handles different types of input fields and ensures proper element state before input.
 	*/
-	locator := bc.GetLocateElement(elementNode)
-
+	locator, err := bc.GetLocateElement(elementNode)
+	if err != nil {
+		return err
+	}
 	if locator == nil {
 		return &BrowserError{Message: "Element: " + elementNode.Xpath + " not found"}
 	}
@@ -425,8 +615,11 @@ func (bc *BrowserContext) InputTextElementNode(elementNode *dom.DOMElementNode,
 	}
 
 	// Get element properties to determine input method
-	tagNameAny, _ := locator.Evaluate("el => el.tagName.toLowerCase()", nil)
-	tagName := tagNameAny.(string)
+	tagNameAny, err := locator.Evaluate("el => el.tagName.toLowerCase()", nil)
+	if err != nil {
+		return NewJSEvaluationError(err)
+	}
+	tagName, _ := tagNameAny.(string)
 
 	if tagName == "input" || tagName == "textarea" {
 		locator.Evaluate("el => { el.textContent = ''; el.value = ''; }", nil)
@@ -453,11 +646,14 @@ func (bc *BrowserContext) InputTextElementNode(elementNode *dom.DOMElementNode,
 
 func (bc *BrowserContext) initializeSession() (*BrowserSession, error) {
 	log.Printf("🌎  Initializing new browser context with id: %s", bc.ContextId)
-	pwBrowser := bc.Browser.GetPlaywrightBrowser()
+	pwBrowser, err := bc.Browser.GetPlaywrightBrowser()
+	if err != nil {
+		return nil, err
+	}
 
 	context, err := bc.createContext(pwBrowser)
 	if err != nil {
-		return nil, err
+		return nil, sessionError(err)
 	}
 	bc.pageEventHandler = nil
 
@@ -468,7 +664,7 @@ func (bc *BrowserContext) initializeSession() (*BrowserSession, error) {
 	}
 
 	var activePage playwright.Page = nil
-	if bc.Browser.Config["cdp_url"] != nil {
+	if bc.Browser.Config.CdpUrl != "" {
 		// If we have a saved target ID, try to find and activate it
 		if bc.State.TargetId != nil {
 			targets := bc.getCdpTargets()
@@ -494,18 +690,20 @@ func (bc *BrowserContext) initializeSession() (*BrowserSession, error) {
 		} else {
 			activePage, err = context.NewPage()
 			if err != nil {
-				return nil, err
+				return nil, sessionError(err)
 			}
 			activePage.Goto("about:blank")
 			log.Printf("🆕  Created new page: %s", activePage.URL())
 		}
 
 		// Get target ID for the active page
-		if bc.Browser.Config["cdp_url"] != nil {
+		if bc.Browser.Config.CdpUrl != "" {
 			targets := bc.getCdpTargets()
 			for _, target := range targets {
 				if target["url"] == activePage.URL() {
-					bc.State.TargetId = playwright.String(activePage.URL())
+					if targetId, ok := target["targetId"].(string); ok {
+						bc.State.TargetId = &targetId
+					}
 					break
 				}
 			}
@@ -516,16 +714,19 @@ func (bc *BrowserContext) initializeSession() (*BrowserSession, error) {
 	activePage.WaitForLoadState() // 'load'
 
 	bc.ActiveTab = activePage
+	// popups and tabs opened by the page are checked against allowed_domains
+	bc.addNewPageListener(context)
 
 	return bc.Session, nil
 }
 
 func (bc *BrowserContext) onPage(page playwright.Page) {
-	if bc.Browser.Config["cdp_url"] != nil {
+	if bc.Browser.Config.CdpUrl != "" {
 		page.Reload()
 	}
 	page.WaitForLoadState()
 	log.Printf("📑  New page opened: %s", page.URL())
+	bc.checkAndHandleNavigation(page)
 
 	if !strings.HasPrefix(page.URL(), "chrome-extension://") && !strings.HasPrefix(page.URL(), "chrome://") {
 		bc.ActiveTab = page
@@ -537,7 +738,8 @@ func (bc *BrowserContext) onPage(page playwright.Page) {
 }
 
 func (bc *BrowserContext) getCdpTargets() []map[string]interface{} {
-	if bc.Browser.Config["cdp_url"] == nil || bc.Session == nil {
+	// CDP sessions are only available in chromium
+	if bc.Browser.Config.CdpUrl == "" || !bc.Browser.isChromium() || bc.Session == nil {
 		return []map[string]interface{}{}
 	}
 	pages := bc.Session.Context.Pages()
@@ -557,74 +759,203 @@ func (bc *BrowserContext) getCdpTargets() []map[string]interface{} {
 	if err != nil {
 		return []map[string]interface{}{}
 	}
-	return result.(map[string]interface{})["targetInfos"].([]map[string]interface{})
+	resultMap, ok := result.(map[string]interface{})
+	if !ok {
+		return []map[string]interface{}{}
+	}
+	targetInfos, _ := resultMap["targetInfos"].([]interface{})
+	targets := make([]map[string]interface{}, 0, len(targetInfos))
+	for _, targetInfo := range targetInfos {
+		if target, ok := targetInfo.(map[string]interface{}); ok {
+			targets = append(targets, target)
+		}
+	}
+	return targets
 }
 
 func (bc *BrowserContext) addNewPageListener(context playwright.BrowserContext) {
-	bc.pageEventHandler = bc.onPage
+	// handlers run on the connection of the driver, onPage waits for the page and must not block it
+	bc.pageEventHandler = func(page playwright.Page) {
+		go bc.onPage(page)
+	}
 	context.OnPage(bc.pageEventHandler)
 }
 
-// TODO(MID): implement isUrlAllowed
+// Check if a URL is allowed based on the allowed_domains and blocked_domains config
 func (bc *BrowserContext) isUrlAllowed(url string) bool {
-	return true
+	if isNewTabPage(url) {
+		return true
+	}
+	for _, pattern := range bc.Config.BlockedDomains {
+		if utils.MatchUrlWithDomainPattern(url, pattern) {
+			return false
+		}
+	}
+	allowedDomains := bc.Config.AllowedDomains
+	if len(allowedDomains) == 0 {
+		return true
+	}
+	// only http and https pages are allowed, unless a pattern names the scheme
+	webPage := strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+	for _, pattern := range allowedDomains {
+		if (webPage || strings.Contains(pattern, "://")) && utils.MatchUrlWithDomainPattern(url, pattern) {
+			return true
+		}
+	}
+	return false
 }
 
-// TODO(MID): implement waitForPageAndFramesLoad
-func (bc *BrowserContext) waitForPageAndFramesLoad(timeoutOverwrite *float64) error {
-	// maxTime := 0.25
-	// if timeoutOverwrite != nil {
-	// 	maxTime = *timeoutOverwrite
-	// }
-	// log.Debug("🪨  Waiting for page and frames to load for %f seconds", maxTime)
-	bc.waitForStableNetwork()
-	return nil
+// Check if the page landed on a non-allowed URL (e.g. after a redirect) and navigate away from it
+func (bc *BrowserContext) checkAndHandleNavigation(page playwright.Page) error {
+	url := page.URL()
+	if bc.isUrlAllowed(url) {
+		return nil
+	}
+	log.Warnf("⛔️  Navigation to non-allowed URL detected: %s", url)
+	if _, err := page.Goto("about:blank"); err != nil {
+		log.Errorf("⛔️  Failed to navigate to about:blank: %s", err)
+	}
+	return NewURLNotAllowedError(url)
 }
 
-// TODO(MID): implement waitForStableNetwork
-func (bc *BrowserContext) waitForStableNetwork() error {
+// Ensures page is fully loaded before continuing.
+// Waits for either network to be idle or minimum_wait_page_load_time, whichever is longer.
+// Also waits for child frames to finish loading.
+// Returns an error only when ctx is done.
+func (bc *BrowserContext) waitForPageAndFramesLoad(ctx context.Context, timeoutOverwrite *float64) error {
+	start := time.Now()
+	if err := bc.waitForStableNetwork(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Warnf("⚠️  Page load failed, continuing anyway: %s", err)
+	}
+	bc.waitForFramesLoad()
+
+	minimumWait := bc.Config.MinimumWaitPageLoadTime
+	if timeoutOverwrite != nil {
+		minimumWait = *timeoutOverwrite
+	}
+	remaining := time.Duration(minimumWait*float64(time.Second)) - time.Since(start)
+	log.Debugf("--Page loaded in %.2f seconds, waiting for additional %.2f seconds", time.Since(start).Seconds(), max(remaining, 0).Seconds())
+	return utils.Sleep(ctx, remaining)
+}
+
+// Wait until no relevant request has been in flight for wait_for_network_idle_page_load_time,
+// capped by maximum_wait_page_load_time
+func (bc *BrowserContext) waitForStableNetwork(ctx context.Context) error {
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return err
+	}
+	idleTime := time.Duration(bc.Config.WaitForNetworkIdlePageLoadTime * float64(time.Second))
+	maxTime := time.Duration(bc.Config.MaximumWaitPageLoadTime * float64(time.Second))
+	tracker := newNetworkTracker(bc.Config.IgnoredNetworkUrlPatterns)
+
+	// requests of child frames are reported by the page as well
+	page.OnRequest(tracker.onRequest)
+	page.OnResponse(tracker.onResponse)
+	page.OnRequestFailed(tracker.onRequestDone)
+	defer func() {
+		page.RemoveListener("request", tracker.onRequest)
+		page.RemoveListener("response", tracker.onResponse)
+		page.RemoveListener("requestfailed", tracker.onRequestDone)
+	}()
+
+	start := time.Now()
+	for {
+		if err := utils.Sleep(ctx, 100*time.Millisecond); err != nil {
+			return err
+		}
+		pending, lastActivity := tracker.status()
+		if pending == 0 && time.Since(lastActivity) >= idleTime {
+			break
+		}
+		if time.Since(start) > maxTime {
+			log.Debugf("Network timeout after %s with %d pending requests", maxTime, pending)
+			break
+		}
+	}
+	log.Debugf("⚖️  Network stabilized for %s", idleTime)
 	return nil
 }
 
+// Wait for the child frames of the current page to reach the DOMContentLoaded state
+func (bc *BrowserContext) waitForFramesLoad() {
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return
+	}
+	maxTime := bc.Config.MaximumWaitPageLoadTime * 1000
+	for _, frame := range page.Frames() {
+		if frame == page.MainFrame() || frame.IsDetached() {
+			continue
+		}
+		err := frame.WaitForLoadState(playwright.FrameWaitForLoadStateOptions{
+			State:   playwright.LoadStateDomcontentloaded,
+			Timeout: playwright.Float(maxTime),
+		})
+		if err != nil {
+			log.Debugf("Frame %s did not finish loading: %s", frame.URL(), err)
+		}
+	}
+}
+
 // Creates a new browser context with anti-detection measures and loads cookies if available.
 func (bc *BrowserContext) createContext(browser playwright.Browser) (playwright.BrowserContext, error) {
 	var context playwright.BrowserContext
-	var err error
-	if bc.Browser.Config["cdp_url"] != nil && len(browser.Contexts()) > 0 {
+	storageState, err := bc.loadStorageState()
+	if err != nil {
+		log.Printf("🍪  Failed to load storage state: %s", err)
+	}
+	proxy := bc.proxy()
+	if proxy != nil && (bc.Browser.Config.CdpUrl != "" || bc.Browser.Config.BrowserBinaryPath != "") && len(browser.Contexts()) > 0 {
+		log.Warnf("Proxy %s can not be applied to the existing context of an attached browser", proxy.Server)
+	}
+	if bc.Browser.Config.CdpUrl != "" && len(browser.Contexts()) > 0 {
 		context = browser.Contexts()[0]
-	} else if bc.Browser.Config["browser_binary_path"] != nil && len(browser.Contexts()) > 0 {
+		if err := bc.applyStorageState(context, storageState); err != nil {
+			log.Printf("🍪  Failed to load cookies: %s", err)
+		}
+	} else if bc.Browser.Config.BrowserBinaryPath != "" && len(browser.Contexts()) > 0 {
 		context = browser.Contexts()[0]
+		if err := bc.applyStorageState(context, storageState); err != nil {
+			log.Printf("🍪  Failed to load cookies: %s", err)
+		}
 	} else {
+		var optionalStorageState *playwright.OptionalStorageState
+		if storageState != nil {
+			optionalStorageState = storageState.ToOptionalStorageState()
+		}
 		context, err = browser.NewContext(
 			playwright.BrowserNewContextOptions{
 				NoViewport:        playwright.Bool(true),
-				UserAgent:         playwright.String(utils.GetDefaultValue(bc.Browser.Config, "user_agent", "")),
+				UserAgent:         playwright.String(bc.Config.UserAgent),
 				JavaScriptEnabled: playwright.Bool(true),
-				BypassCSP:         playwright.Bool(bc.Browser.Config["disable_security"].(bool)),
-				IgnoreHttpsErrors: playwright.Bool(bc.Browser.Config["disable_security"].(bool)),
-				// RecordVideo: &playwright.RecordVideo{
-				// 	Dir: bc.Browser.Config["save_recording_path"].(string),
-				// 	Size: &playwright.Size{
-				// 		Width:  bc.Browser.Config["browser_window_size"].(map[string]interface{})["width"].(int),
-				// 		Height: bc.Browser.Config["browser_window_size"].(map[string]interface{})["height"].(int),
-				// 	},
-				// },
-				// RecordHarPath:   playwright.String(bc.Browser.Config["save_har_path"].(string)),
-				Locale:          playwright.String(utils.GetDefaultValue(bc.Browser.Config, "locale", "")),
-				HttpCredentials: utils.GetDefaultValue[*playwright.HttpCredentials](bc.Browser.Config, "http_credentials", nil),
-				IsMobile:        playwright.Bool(utils.GetDefaultValue(bc.Browser.Config, "is_mobile", false)),
-				HasTouch:        playwright.Bool(bc.Browser.Config["has_touch"].(bool)),
-				// Geolocation: bc.Browser.Config["geolocation"].(*playwright.Geolocation),
-				// Permissions:     bc.Browser.Config["permissions"].([]string),
-				TimezoneId: playwright.String(utils.GetDefaultValue(bc.Browser.Config, "timezone_id", "")),
+				BypassCSP:         playwright.Bool(bc.Config.DisableSecurity),
+				IgnoreHttpsErrors: playwright.Bool(bc.Config.DisableSecurity),
+				RecordVideo:       bc.recordVideoOptions(),
+				RecordHarPath:     bc.recordHarPath(),
+				Locale:            playwright.String(bc.Config.Locale),
+				HttpCredentials:   bc.Config.HttpCredentials,
+				IsMobile:          playwright.Bool(bc.Config.IsMobile),
+				HasTouch:          playwright.Bool(bc.Config.HasTouch),
+				// Geolocation: bc.Config.Geolocation,
+				// Permissions:     bc.Config.Permissions,
+				TimezoneId:   playwright.String(bc.Config.TimezoneId),
+				StorageState: optionalStorageState,
+				Proxy:        bc.Config.Proxy.toPlaywright(),
 			},
 		)
 		if err != nil {
 			return nil, err
 		}
 	}
+	bc.trackVideos(context)
+	if storageState != nil {
+		log.Printf("🍪  Loaded %d cookies", len(storageState.Cookies))
+	}
 
-	// TODO(MID): provide cookie_path
 	initScript := `// Webdriver property
             Object.defineProperty(navigator, 'webdriver', {
                 get: () => undefined
@@ -657,25 +988,28 @@ func (bc *BrowserContext) createContext(browser playwright.Browser) (playwright.
                 };
             })();`
 	context.AddInitScript(playwright.Script{Content: &initScript})
+	if err := bc.setupRequestBlocking(context); err != nil {
+		return nil, err
+	}
 	return context, nil
 }
 
-func (bc *BrowserContext) getCurrentPage(session *BrowserSession) playwright.Page {
+func (bc *BrowserContext) getCurrentPage(session *BrowserSession) (playwright.Page, error) {
 	pages := session.Context.Pages()
-	if bc.Browser.Config["cdp_url"] != nil && bc.State.TargetId != nil {
+	if bc.Browser.Config.CdpUrl != "" && bc.State.TargetId != nil {
 		targets := bc.getCdpTargets()
 		for _, target := range targets {
 			if target["targetId"] == *bc.State.TargetId {
 				for _, page := range pages {
 					if page.URL() == target["url"] {
-						return page
+						return page, nil
 					}
 				}
 			}
 		}
 	}
 	if bc.ActiveTab != nil && !bc.ActiveTab.IsClosed() && slices.Contains(session.Context.Pages(), bc.ActiveTab) {
-		return bc.ActiveTab
+		return bc.ActiveTab, nil
 	}
 
 	// fall back to most recently opened non-extension page (extensions are almost always invisible background targets)
@@ -686,27 +1020,31 @@ func (bc *BrowserContext) getCurrentPage(session *BrowserSession) playwright.Pag
 		}
 	}
 	if len(nonExtensionPages) > 0 {
-		return nonExtensionPages[len(nonExtensionPages)-1]
+		return nonExtensionPages[len(nonExtensionPages)-1], nil
 	}
 	page, err := session.Context.NewPage()
 	if err == nil {
-		return page
+		return page, nil
 	}
+	// the context is gone, start over with a new session
 	session, err = bc.initializeSession()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	page, err = session.Context.NewPage()
 	if err != nil {
-		panic(err)
+		return nil, sessionError(err)
 	}
 	bc.ActiveTab = page
-	return page
+	return page, nil
 }
 
-func (bc *BrowserContext) GetTabsInfo() []*TabInfo {
+func (bc *BrowserContext) GetTabsInfo() ([]*TabInfo, error) {
 	// Get information about all tabs
-	session := bc.GetSession()
+	session, err := bc.GetSession()
+	if err != nil {
+		return nil, err
+	}
 
 	tabsInfo := []*TabInfo{}
 	for pageId, page := range session.Context.Pages() {
@@ -719,12 +1057,15 @@ func (bc *BrowserContext) GetTabsInfo() []*TabInfo {
 		}
 		tabsInfo = append(tabsInfo, &tabInfo)
 	}
-	return tabsInfo
+	return tabsInfo, nil
 }
 
 func (bc *BrowserContext) SwitchToTab(pageId int) error {
 	// Switch to a specific tab by its PageId
-	session := bc.GetSession()
+	session, err := bc.GetSession()
+	if err != nil {
+		return err
+	}
 	pages := session.Context.Pages()
 
 	if pageId >= len(pages) {
@@ -742,7 +1083,7 @@ func (bc *BrowserContext) SwitchToTab(pageId int) error {
 	}
 
 	// Update target ID if using CDP
-	if bc.Browser.Config["cdp_url"] != nil {
+	if bc.Browser.Config.CdpUrl != "" {
 		targets := bc.getCdpTargets()
 		for _, target := range targets {
 			if target["url"] == page.URL() {
@@ -762,8 +1103,11 @@ func (bc *BrowserContext) SwitchToTab(pageId int) error {
 }
 
 func (bc *BrowserContext) GoBack() error {
-	page := bc.GetCurrentPage()
-	_, err := page.GoBack(playwright.PageGoBackOptions{Timeout: playwright.Float(1000), WaitUntil: playwright.WaitUntilStateDomcontentloaded})
+	page, err := bc.GetCurrentPage()
+	if err != nil {
+		return err
+	}
+	_, err = page.GoBack(playwright.PageGoBackOptions{Timeout: playwright.Float(1000), WaitUntil: playwright.WaitUntilStateDomcontentloaded})
 	if err != nil {
 		return err
 	}
@@ -775,27 +1119,37 @@ func (bc *BrowserContext) CreateNewTab(url string) error {
 	if len(url) > 0 && !bc.isUrlAllowed(url) {
 		return &BrowserError{Message: "Cannot create new tab with non-allowed URL: " + url}
 	}
-	session := bc.GetSession()
-	newPage, err := session.Context.NewPage()
+	session, err := bc.GetSession()
 	if err != nil {
 		return err
 	}
+	newPage, err := session.Context.NewPage()
+	if err != nil {
+		return sessionError(err)
+	}
 
 	bc.ActiveTab = newPage
 
 	newPage.WaitForLoadState(playwright.PageWaitForLoadStateOptions{Timeout: playwright.Float(500)})
 
 	if len(url) > 0 {
-		_, err := newPage.Goto(url)
-		bc.waitForPageAndFramesLoad(playwright.Float(1.0))
-		if err != nil {
+		response, err := newPage.Goto(url)
+		if err := bc.checkProxyAuth(response, err); err != nil {
+			// a redirect to a non-allowed URL is reported over the navigation error
+			if navErr := bc.checkAndHandleNavigation(newPage); navErr != nil {
+				return navErr
+			}
 			return err
 		}
+		bc.waitForPageAndFramesLoad(context.Background(), playwright.Float(1.0))
+		if navErr := bc.checkAndHandleNavigation(newPage); navErr != nil {
+			return navErr
+		}
 	}
 
 	// TODO(MID): check CDP
 	// Get target ID for new page if using CDP
-	// if bc.Browser.Config["cdp_url"] != nil {
+	// if bc.Browser.Config.CdpUrl != "" {
 	// 	targets := bc.getCdpTargets()
 	// 	for _, target := range targets {
 	// 		if target["url"] == newPage.URL() {
@@ -811,11 +1165,15 @@ func (bc *BrowserContext) CreateNewTab(url string) error {
 // Removes all highlight overlays and labels created by the highlightElement function.
 // Handles cases where the page might be closed or inaccessible.
 func (bc *BrowserContext) RemoveHighlights() {
-	page := bc.GetCurrentPage()
-	if page == nil {
+	// nothing is highlighted before the session is started
+	if bc.Session == nil {
+		return
+	}
+	page, err := bc.GetCurrentPage()
+	if err != nil {
 		return
 	}
-	_, err := page.Evaluate(` try {
+	_, err = page.Evaluate(` try {
                     // Remove the highlight container and all its contents
                     const container = document.getElementById('playwright-highlight-container');
                     if (container) {
@@ -0,0 +1,252 @@
+package browser
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nerdface-ai/browser-use-go/internals/dom"
+	"github.com/nerdface-ai/browser-use-go/internals/utils"
+
+	"github.com/charmbracelet/log"
+	"github.com/playwright-community/playwright-go"
+)
+
+// Policy for blocking subresource requests, built from the browser config:
+//
+//	block_ads               block requests to dom.DefaultAdDomains
+//	blocked_request_domains domain patterns to block, e.g. "*.hotjar.com"
+//	blocked_resource_types  playwright resource types to block, e.g. "image", "font", "media"
+//	blocked_url_patterns    url globs to block, * matches any characters
+//
+// xhr and fetch requests are also checked against allowed_domains and blocked_domains.
+type requestPolicy struct {
+	blockedDomains       []string
+	blockedResourceTypes []string
+	blockedUrlPatterns   []*regexp.Regexp
+	checkAllowedDomains  bool
+}
+
+func newRequestPolicy(config BrowserContextConfig) *requestPolicy {
+	policy := &requestPolicy{
+		blockedDomains:       slices.Clone(config.BlockedRequestDomains),
+		blockedResourceTypes: config.BlockedResourceTypes,
+		checkAllowedDomains:  len(config.AllowedDomains) > 0 || len(config.BlockedDomains) > 0,
+	}
+	if config.BlockAds {
+		for _, domain := range dom.DefaultAdDomains {
+			// ads are served from subdomains, e.g. stats.g.doubleclick.net
+			policy.blockedDomains = append(policy.blockedDomains, "*."+domain)
+		}
+	}
+	for _, pattern := range config.BlockedUrlPatterns {
+		glob := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+		policy.blockedUrlPatterns = append(policy.blockedUrlPatterns, regexp.MustCompile("^"+glob+"$"))
+	}
+	return policy
+}
+
+func (p *requestPolicy) isEmpty() bool {
+	return len(p.blockedDomains) == 0 &&
+		len(p.blockedResourceTypes) == 0 &&
+		len(p.blockedUrlPatterns) == 0 &&
+		!p.checkAllowedDomains
+}
+
+// Returns why a request should be blocked, or an empty string if it is allowed
+func (bc *BrowserContext) blockReason(policy *requestPolicy, url string, resourceType string) string {
+	// never block documents by type, the page would not load at all
+	if resourceType != "document" && slices.Contains(policy.blockedResourceTypes, resourceType) {
+		return "resource type"
+	}
+	for _, pattern := range policy.blockedDomains {
+		if utils.MatchUrlWithDomainPattern(url, pattern) {
+			return "domain"
+		}
+	}
+	for _, pattern := range policy.blockedUrlPatterns {
+		if pattern.MatchString(url) {
+			return "url pattern"
+		}
+	}
+	if policy.checkAllowedDomains && (resourceType == "xhr" || resourceType == "fetch") && !bc.isUrlAllowed(url) {
+		return "allowed domains"
+	}
+	return ""
+}
+
+// Install a route handler on the context which aborts requests blocked by the request policy
+func (bc *BrowserContext) setupRequestBlocking(context playwright.BrowserContext) error {
+	policy := newRequestPolicy(bc.Config)
+	if policy.isEmpty() {
+		return nil
+	}
+	return context.Route("**/*", func(route playwright.Route) {
+		request := route.Request()
+		// top level navigations are checked by isUrlAllowed
+		if request.IsNavigationRequest() && request.Frame() != nil && request.Frame().ParentFrame() == nil {
+			route.Continue()
+			return
+		}
+		if reason := bc.blockReason(policy, request.URL(), request.ResourceType()); reason != "" {
+			log.Debugf("🚫  Blocked request by %s: %s", reason, request.URL())
+			bc.countBlockedRequest(reason)
+			route.Abort("blockedbyclient")
+			return
+		}
+		route.Continue()
+	})
+}
+
+func (bc *BrowserContext) countBlockedRequest(reason string) {
+	bc.blockedRequestsMu.Lock()
+	defer bc.blockedRequestsMu.Unlock()
+	if bc.blockedRequests == nil {
+		bc.blockedRequests = make(map[string]int)
+	}
+	bc.blockedRequests[reason]++
+}
+
+// Returns a summary of the requests blocked since the last call and resets the counts
+func (bc *BrowserContext) popBlockedRequests() []string {
+	bc.blockedRequestsMu.Lock()
+	defer bc.blockedRequestsMu.Unlock()
+	if len(bc.blockedRequests) == 0 {
+		return nil
+	}
+	reasons := make([]string, 0, len(bc.blockedRequests))
+	for reason := range bc.blockedRequests {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	summary := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		summary = append(summary, fmt.Sprintf("Blocked %d requests by %s", bc.blockedRequests[reason], reason))
+	}
+	bc.blockedRequests = nil
+	return summary
+}
+
+// Resource types which have to finish loading for a page to be considered loaded
+var relevantResourceTypes = []string{"document", "stylesheet", "image", "font", "script", "iframe"}
+
+// Content types of responses which are part of loading a page
+var relevantContentTypes = []string{"text/html", "text/css", "application/javascript", "image/", "font/", "application/json"}
+
+// Content types of streaming responses, which never finish
+var streamingContentTypes = []string{"streaming", "video", "audio", "webm", "mp4", "event-stream", "websocket", "protobuf"}
+
+// Url fragments of analytics, ads, chat widgets, websockets and long-polling requests which are not waited for
+var ignoredNetworkUrlPatterns = []string{
+	// analytics and tracking
+	"analytics", "tracking", "telemetry", "beacon", "metrics",
+	// ads and social widgets
+	"doubleclick", "adsystem", "adserver", "advertising",
+	"facebook.com/plugins", "platform.twitter", "linkedin.com/embed",
+	// live chat and support widgets
+	"livechat", "zendesk", "intercom", "crisp.chat", "hotjar",
+	// push notifications
+	"push-notifications", "onesignal.com", "webpushr",
+	// background and long-polling requests
+	".well-known", "heartbeat", "ping", "alive", "poll", "socket.io",
+	// streaming
+	"webrtc", "rtmp://", "wss://", "ws://",
+	// cdn assets which are loaded lazily
+	"cloudfront.net", "fastly.net",
+}
+
+// Tracks in-flight requests of a page which are relevant for the page load
+type networkTracker struct {
+	mu             sync.Mutex
+	pending        map[playwright.Request]struct{}
+	lastActivity   time.Time
+	ignoredPattern []string
+}
+
+func newNetworkTracker(extraIgnoredPatterns []string) *networkTracker {
+	return &networkTracker{
+		pending:        make(map[playwright.Request]struct{}),
+		lastActivity:   time.Now(),
+		ignoredPattern: append(slices.Clone(ignoredNetworkUrlPatterns), extraIgnoredPatterns...),
+	}
+}
+
+// Reports whether the page load has to wait for a request
+func (t *networkTracker) isRelevantRequest(url string, resourceType string, headers map[string]string) bool {
+	if !slices.Contains(relevantResourceTypes, resourceType) {
+		return false
+	}
+	url = strings.ToLower(url)
+	if strings.HasPrefix(url, "data:") || strings.HasPrefix(url, "blob:") {
+		return false
+	}
+	for _, pattern := range t.ignoredPattern {
+		if strings.Contains(url, pattern) {
+			return false
+		}
+	}
+	if headers["purpose"] == "prefetch" || headers["sec-fetch-dest"] == "video" || headers["sec-fetch-dest"] == "audio" {
+		return false
+	}
+	return true
+}
+
+// Reports whether a response counts as page load activity
+func isRelevantResponse(headers map[string]string) bool {
+	contentType := strings.ToLower(headers["content-type"])
+	for _, streaming := range streamingContentTypes {
+		if strings.Contains(contentType, streaming) {
+			return false
+		}
+	}
+	for _, relevant := range relevantContentTypes {
+		if strings.Contains(contentType, relevant) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *networkTracker) onRequest(request playwright.Request) {
+	if !t.isRelevantRequest(request.URL(), request.ResourceType(), request.Headers()) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[request] = struct{}{}
+	t.lastActivity = time.Now()
+}
+
+func (t *networkTracker) onResponse(response playwright.Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	request := response.Request()
+	if _, ok := t.pending[request]; !ok {
+		return
+	}
+	delete(t.pending, request)
+	// streaming and irrelevant responses end the request without counting as activity
+	if isRelevantResponse(response.Headers()) {
+		t.lastActivity = time.Now()
+	}
+}
+
+func (t *networkTracker) onRequestDone(request playwright.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pending[request]; ok {
+		delete(t.pending, request)
+		t.lastActivity = time.Now()
+	}
+}
+
+// Returns the number of pending requests and the time of the last network activity
+func (t *networkTracker) status() (int, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending), t.lastActivity
+}
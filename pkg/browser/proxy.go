@@ -0,0 +1,90 @@
+package browser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// Proxy the browser traffic is sent through, set with BrowserConfig.Proxy.
+// BrowserContextConfig.Proxy overrides it, so one browser can serve contexts through different proxies.
+type ProxySettings struct {
+	Server   string `json:"server"`             // e.g. "http://myproxy.com:3128" or "socks5://myproxy.com:3128"
+	Bypass   string `json:"bypass,omitempty"`   // comma separated domains to bypass, e.g. ".com, chromium.org"
+	Username string `json:"username,omitempty"` // username for HTTP proxy authentication
+	Password string `json:"password,omitempty"` // password for HTTP proxy authentication
+}
+
+// UnmarshalJSON also accepts the server as a plain string
+func (p *ProxySettings) UnmarshalJSON(data []byte) error {
+	var server string
+	if err := json.Unmarshal(data, &server); err == nil {
+		*p = ProxySettings{Server: server}
+		return nil
+	}
+	type plain ProxySettings
+	return json.Unmarshal(data, (*plain)(p))
+}
+
+func (p *ProxySettings) Validate() error {
+	if p.Server == "" {
+		return &BrowserError{Message: "proxy server should be a non-empty string"}
+	}
+	return nil
+}
+
+// Returns the proxy of the context, or the proxy of the browser if the context does not override it
+func (bc *BrowserContext) proxy() *ProxySettings {
+	if bc.Config.Proxy != nil {
+		return bc.Config.Proxy
+	}
+	if bc.Browser != nil {
+		return bc.Browser.Config.Proxy
+	}
+	return nil
+}
+
+func (p *ProxySettings) toPlaywright() *playwright.Proxy {
+	if p == nil {
+		return nil
+	}
+	proxy := &playwright.Proxy{Server: p.Server}
+	if p.Bypass != "" {
+		proxy.Bypass = playwright.String(p.Bypass)
+	}
+	if p.Username != "" {
+		proxy.Username = playwright.String(p.Username)
+		proxy.Password = playwright.String(p.Password)
+	}
+	return proxy
+}
+
+// Network errors of chromium, firefox and webkit when the proxy rejects the credentials
+var proxyAuthErrors = []string{
+	"ERR_PROXY_AUTH",
+	"ERR_INVALID_AUTH_CREDENTIALS",
+	"NS_ERROR_PROXY_AUTHENTICATION_FAILED",
+	"Proxy Authentication Required",
+}
+
+// Turns a failed navigation or a 407 response into a BrowserError if the context uses a proxy
+func (bc *BrowserContext) checkProxyAuth(response playwright.Response, err error) error {
+	proxy := bc.proxy()
+	if proxy == nil {
+		return err
+	}
+	if err != nil {
+		for _, proxyErr := range proxyAuthErrors {
+			if strings.Contains(err.Error(), proxyErr) {
+				return &BrowserError{Message: fmt.Sprintf("Proxy authentication failed for %s: %s", proxy.Server, err)}
+			}
+		}
+		return err
+	}
+	if response != nil && response.Status() == 407 {
+		return &BrowserError{Message: fmt.Sprintf("Proxy authentication failed for %s: 407 Proxy Authentication Required", proxy.Server)}
+	}
+	return nil
+}
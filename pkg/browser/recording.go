@@ -0,0 +1,84 @@
+package browser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/playwright-community/playwright-go"
+)
+
+// Video and HAR recording of a context, configured with:
+//
+//	save_recording_path  directory for the page videos, saved as <ContextId>-<n>.webm
+//	save_har_path        directory for the HAR of the context, saved as <ContextId>.har
+//	browser_window_size  {"width": .., "height": ..} of the window, also used as the video size
+//
+// Both files are complete once the context is closed.
+func (bc *BrowserContext) recordVideoOptions() *playwright.RecordVideo {
+	dir := bc.Config.SaveRecordingPath
+	if dir == "" {
+		return nil
+	}
+	width, height := bc.windowSize()
+	return &playwright.RecordVideo{
+		Dir:  dir,
+		Size: &playwright.Size{Width: width, Height: height},
+	}
+}
+
+func (bc *BrowserContext) recordHarPath() *string {
+	dir := bc.Config.SaveHarPath
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("🎥  Failed to create HAR directory %s: %s", dir, err)
+		return nil
+	}
+	bc.HarPath = filepath.Join(dir, bc.ContextId+".har")
+	return &bc.HarPath
+}
+
+// Window size from browser_window_size, defaults to 1280x1100
+func (bc *BrowserContext) windowSize() (int, int) {
+	size := bc.Config.BrowserWindowSize
+	if size.Width > 0 && size.Height > 0 {
+		return size.Width, size.Height
+	}
+	return 1280, 1100
+}
+
+// Remember the video of every page of the context, the videos are saved when the context is closed
+func (bc *BrowserContext) trackVideos(context playwright.BrowserContext) {
+	if bc.recordVideoOptions() == nil {
+		return
+	}
+	for _, page := range context.Pages() {
+		bc.trackVideo(page)
+	}
+	context.OnPage(bc.trackVideo)
+}
+
+func (bc *BrowserContext) trackVideo(page playwright.Page) {
+	if video := page.Video(); video != nil {
+		bc.videos = append(bc.videos, video)
+	}
+}
+
+// Save the recorded videos under names based on ContextId. Must be called after the context is closed.
+func (bc *BrowserContext) saveRecordings() {
+	dir := bc.Config.SaveRecordingPath
+	for i, video := range bc.videos {
+		path := filepath.Join(dir, fmt.Sprintf("%s-%d.webm", bc.ContextId, i+1))
+		if err := video.SaveAs(path); err != nil {
+			log.Printf("🎥  Failed to save recording: %s", err)
+			continue
+		}
+		video.Delete()
+		bc.RecordingPaths = append(bc.RecordingPaths, path)
+		log.Printf("🎥  Saved recording to %s", path)
+	}
+	bc.videos = nil
+}
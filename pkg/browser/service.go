@@ -1,118 +1,394 @@
 package browser
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/adrg/xdg"
+	"github.com/charmbracelet/log"
 	"github.com/google/uuid"
 	"github.com/playwright-community/playwright-go"
 )
 
 var IN_DOCKER = os.Getenv("IN_DOCKER") == "true"
 
-type BrowserConfig = map[string]interface{}
-
-func NewBrowserConfig() BrowserConfig {
-	return BrowserConfig{
-		"headless":         false,
-		"disable_security": false,
-		"browser_class":    "chromium",
-		"is_mobile":        false,
-		"has_touch":        false,
-	}
-}
-
 type Browser struct {
 	Config            BrowserConfig
 	Playwright        *playwright.Playwright
 	PlaywrightBrowser playwright.Browser
+
+	chromeProcess *exec.Cmd
 }
 
-func NewBrowser(customConfig BrowserConfig) *Browser {
-	config := NewBrowserConfig()
-	for key, value := range customConfig {
-		config[key] = value
+func NewBrowser(config BrowserConfig) (*Browser, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
 	return &Browser{
 		Config:            config,
 		Playwright:        nil,
 		PlaywrightBrowser: nil,
+	}, nil
+}
+
+// Create a browser from a config in the old map form, see BrowserConfigFromMap
+//
+// Deprecated: use NewBrowser with a BrowserConfig
+func NewBrowserFromMap(customConfig map[string]interface{}) (*Browser, error) {
+	config, err := BrowserConfigFromMap(customConfig)
+	if err != nil {
+		return nil, err
 	}
+	return NewBrowser(config)
 }
 
+// Create a browser context with the NewContextConfig of the browser
 func (b *Browser) NewContext() *BrowserContext {
 	return &BrowserContext{
 		ContextId: uuid.New().String(),
-		Config:    b.Config,
+		Config:    b.Config.NewContextConfig,
 		Browser:   b,
 		Session:   nil,
 		State:     &BrowserContextState{},
 	}
 }
 
-// Get a browser context
-func (b *Browser) GetPlaywrightBrowser() playwright.Browser {
+// Create a browser context with its own config, e.g. to use its own proxy
+func (b *Browser) NewContextWithConfig(config BrowserContextConfig) (*BrowserContext, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	bc := b.NewContext()
+	bc.Config = config
+	return bc, nil
+}
+
+// Get the playwright browser, it is launched or connected to on first use
+func (b *Browser) GetPlaywrightBrowser() (playwright.Browser, error) {
 	if b.PlaywrightBrowser == nil {
 		return b.init()
 	}
-	return b.PlaywrightBrowser
+	if !b.PlaywrightBrowser.IsConnected() {
+		if !b.isAttached() {
+			return nil, NewSessionClosedError(errors.New("the browser has been closed"))
+		}
+		browser, err := b.reconnect()
+		if err != nil {
+			log.Errorf("🔌  Failed to reconnect to remote browser: %s", err)
+			return nil, NewSessionClosedError(err)
+		}
+		b.PlaywrightBrowser = browser
+	}
+	return b.PlaywrightBrowser, nil
+}
+
+// Reports whether the browser is attached to over CDP or WebSocket instead of launched by playwright
+func (b *Browser) isAttached() bool {
+	return b.Config.CdpUrl != "" || b.Config.WssUrl != "" || b.Config.BrowserBinaryPath != ""
 }
 
 func (b *Browser) Close(options ...playwright.BrowserCloseOptions) error {
-	if b.PlaywrightBrowser == nil {
-		return nil
+	var err error
+	if b.PlaywrightBrowser != nil {
+		err = b.PlaywrightBrowser.Close(options...)
+	}
+	// the profile is kept in the user data dir, the chrome process itself is ours to stop
+	if b.chromeProcess != nil && b.chromeProcess.Process != nil {
+		if killErr := b.chromeProcess.Process.Kill(); killErr != nil {
+			log.Warnf("Failed to stop chrome process: %s", killErr)
+		}
+		b.chromeProcess.Wait()
+		b.chromeProcess = nil
 	}
-	return b.PlaywrightBrowser.Close(options...)
+	// stop the node driver, every launch starts a new one
+	if b.Playwright != nil {
+		if stopErr := b.Playwright.Stop(); stopErr != nil && err == nil {
+			err = stopErr
+		}
+		b.Playwright = nil
+	}
+	return err
 }
 
-func (b *Browser) init() playwright.Browser {
+func (b *Browser) init() (playwright.Browser, error) {
 	playwright, err := playwright.Run()
 	if err != nil {
-		panic(err)
+		return nil, NewBrowserLaunchError(err)
 	}
 	b.Playwright = playwright
 
-	b.PlaywrightBrowser = b.setupBrowser(playwright)
-	return b.PlaywrightBrowser
+	browser, err := b.setupBrowser(playwright)
+	if err != nil {
+		if stopErr := playwright.Stop(); stopErr != nil {
+			log.Warnf("Failed to stop playwright driver: %s", stopErr)
+		}
+		b.Playwright = nil
+		var launchErr *BrowserLaunchError
+		if !errors.As(err, &launchErr) {
+			err = NewBrowserLaunchError(err)
+		}
+		return nil, err
+	}
+	b.PlaywrightBrowser = browser
+	return b.PlaywrightBrowser, nil
 }
 
-// TODO(MID): implement remote browser setup
-func (b *Browser) setupBrowser(pw *playwright.Playwright) playwright.Browser {
-	// if b.Config["cdp_url"] != nil {
-	// 	return self.setupRemoteCdpBrowser(playwright)
-	// }
-	// if self.Config["wss_url"] != nil {
-	// 	return self.setupRemoteWssBrowser(playwright)
-	// }
+func (b *Browser) setupBrowser(pw *playwright.Playwright) (playwright.Browser, error) {
+	if err := b.Config.Validate(); err != nil {
+		return nil, err
+	}
+	if b.Config.CdpUrl != "" {
+		return b.setupRemoteCdpBrowser(pw)
+	}
+	if b.Config.WssUrl != "" {
+		return b.setupRemoteWssBrowser(pw)
+	}
 
-	// if self.Config["headless"] != nil {
+	// if self.Config.Headless {
 	// 	log.Println("⚠️ Headless mode is not recommended. Many sites will detect and block all headless browsers.")
 	// }
 
-	// if b.Config["browser_binary_path"] != nil {
-	// 	return b.setupUserProvidedBrowser(playwright)
-	// }
+	if b.Config.BrowserBinaryPath != "" {
+		return b.setupUserProvidedBrowser(pw)
+	}
 	return b.setupBuiltinBrowser(pw)
 }
 
-// func (self *Browser) setupRemoteCdpBrowser(playwright playwright.Playwright) playwright.Browser {
-// }
+// Sets up and returns a Browser instance connected to a remote browser over the Chrome DevTools Protocol.
+func (b *Browser) setupRemoteCdpBrowser(pw *playwright.Playwright) (playwright.Browser, error) {
+	cdpUrl := b.Config.CdpUrl
+	log.Infof("🔌  Connecting to remote browser via CDP %s", cdpUrl)
+	return b.connectOverCDP(pw, cdpUrl)
+}
+
+func (b *Browser) connectOverCDP(pw *playwright.Playwright, cdpUrl string) (playwright.Browser, error) {
+	browser, err := pw.Chromium.ConnectOverCDP(cdpUrl, playwright.BrowserTypeConnectOverCDPOptions{
+		Timeout: playwright.Float(b.connectTimeout()),
+		Headers: b.Config.ConnectHeaders,
+	})
+	if err != nil {
+		return nil, &BrowserError{Message: fmt.Sprintf("Failed to connect to remote browser via CDP %s: %s", cdpUrl, err)}
+	}
+	b.watchDisconnect(browser)
+	return browser, nil
+}
+
+// Sets up and returns a Browser instance connected to a remote playwright browser server over WebSocket.
+func (b *Browser) setupRemoteWssBrowser(pw *playwright.Playwright) (playwright.Browser, error) {
+	wssUrl := b.Config.WssUrl
+	log.Infof("🔌  Connecting to remote browser via WSS %s", wssUrl)
+	browser, err := b.browserType(pw).Connect(wssUrl, playwright.BrowserTypeConnectOptions{
+		Timeout: playwright.Float(b.connectTimeout()),
+		Headers: b.Config.ConnectHeaders,
+	})
+	if err != nil {
+		return nil, &BrowserError{Message: fmt.Sprintf("Failed to connect to remote browser via WSS %s: %s", wssUrl, err)}
+	}
+	b.watchDisconnect(browser)
+	return browser, nil
+}
+
+// Sets up and returns a Browser instance by launching the chrome executable at browser_binary_path
+// with a persistent profile and attaching to it over CDP. A chrome already listening on the debug port is reused.
+func (b *Browser) setupUserProvidedBrowser(pw *playwright.Playwright) (playwright.Browser, error) {
+	binaryPath := b.Config.BrowserBinaryPath
+	port := b.Config.BrowserDebugPort
+	if port == 0 {
+		port = CHROME_DEBUG_PORT
+	}
+	cdpUrl := fmt.Sprintf("http://localhost:%d", port)
+
+	if isDebugPortReady(cdpUrl) {
+		log.Infof("🔌  Reusing existing browser found on %s", cdpUrl)
+		return b.connectOverCDP(pw, cdpUrl)
+	}
 
-// func (self *Browser) setupRemoteWssBrowser(playwright playwright.Playwright) playwright.Browser {
-// }
+	userDataDir := b.Config.BrowserUserDataDir
+	if userDataDir == "" {
+		userDataDir = filepath.Join(xdg.ConfigHome, "browseruse", CHROME_PROFILE_PATH)
+	}
+	if err := os.MkdirAll(userDataDir, 0755); err != nil {
+		return nil, &BrowserError{Message: fmt.Sprintf("Failed to create user data dir %s: %s", userDataDir, err)}
+	}
 
-// func (b *Browser) setupUserProvidedBrowser(playwright playwright.Playwright) playwright.Browser {
-// }
+	cmd := exec.Command(binaryPath, b.userProvidedBrowserArgs(port, userDataDir)...)
+	if err := cmd.Start(); err != nil {
+		return nil, &BrowserError{Message: fmt.Sprintf("Failed to start browser %s: %s", binaryPath, err)}
+	}
+	b.chromeProcess = cmd
+	log.Infof("🚀  Started %s with profile %s", binaryPath, userDataDir)
+
+	// wait for the debug port to come up
+	deadline := time.Now().Add(time.Duration(b.connectTimeout()) * time.Millisecond)
+	for !isDebugPortReady(cdpUrl) {
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			cmd.Wait()
+			b.chromeProcess = nil
+			return nil, &BrowserError{Message: fmt.Sprintf("Browser did not open the debug port %d in time. Close any running chrome using the same profile and try again", port)}
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return b.connectOverCDP(pw, cdpUrl)
+}
+
+func (b *Browser) userProvidedBrowserArgs(port int, userDataDir string) []string {
+	profileDirectory := b.Config.BrowserProfileDirectory
+	if profileDirectory == "" {
+		profileDirectory = CHROME_PROFILE_USER
+	}
+	args := []string{
+		"--user-data-dir=" + userDataDir,
+		"--profile-directory=" + profileDirectory,
+	}
+	for _, arg := range CHROME_ARGS {
+		if strings.HasPrefix(arg, "--remote-debugging-port=") {
+			arg = "--remote-debugging-port=" + strconv.Itoa(port)
+		}
+		// the first window is the one we attach to
+		if arg == "--no-startup-window" {
+			continue
+		}
+		args = append(args, arg)
+	}
+	if IN_DOCKER {
+		args = append(args, CHROME_DOCKER_ARGS...)
+	}
+	if b.Config.Headless {
+		args = append(args, CHROME_HEADLESS_ARGS...)
+	}
+	if b.Config.DisableSecurity {
+		args = append(args, CHROME_DISABLE_SECURITY_ARGS...)
+	}
+	if b.Config.DeterministicRendering {
+		args = append(args, CHROME_DETERMINISTIC_RENDERING_ARGS...)
+	}
+	args = append(args, b.Config.ExtraBrowserArgs...)
+	return args
+}
+
+// Reports whether a browser answers on the CDP endpoint
+func isDebugPortReady(cdpUrl string) bool {
+	client := http.Client{Timeout: time.Second}
+	resp, err := client.Get(cdpUrl + "/json/version")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// Timeout in milliseconds for connecting to a remote browser, connect_timeout in the config
+func (b *Browser) connectTimeout() float64 {
+	if b.Config.ConnectTimeout == 0 {
+		return 30000
+	}
+	return b.Config.ConnectTimeout
+}
+
+func (b *Browser) watchDisconnect(browser playwright.Browser) {
+	browser.OnDisconnected(func(playwright.Browser) {
+		log.Warn("🔌  Remote browser disconnected, reconnecting on next use")
+	})
+}
+
+// Reconnect to the remote browser, retrying reconnect_attempts times with a growing delay.
+// The browser is tried at least once, also when reconnect_attempts is 0.
+func (b *Browser) reconnect() (playwright.Browser, error) {
+	if b.Playwright == nil {
+		return nil, errors.New("the playwright driver has been stopped")
+	}
+	attempts := max(b.Config.ReconnectAttempts, 1)
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		log.Infof("🔌  Reconnecting to remote browser (attempt %d/%d)", attempt, attempts)
+		var browser playwright.Browser
+		browser, err = b.setupBrowser(b.Playwright)
+		if err == nil {
+			return browser, nil
+		}
+		if attempt < attempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	return nil, err
+}
+
+// Browser engines which can be selected with browser_class
+const (
+	BrowserClassChromium = "chromium"
+	BrowserClassFirefox  = "firefox"
+	BrowserClassWebkit   = "webkit"
+)
+
+// Returns the configured browser engine, defaults to chromium
+func (b *Browser) browserClass() string {
+	class := strings.ToLower(b.Config.BrowserClass)
+	switch class {
+	case BrowserClassChromium, BrowserClassFirefox, BrowserClassWebkit:
+		return class
+	}
+	return BrowserClassChromium
+}
+
+// Reports whether the browser supports the Chrome DevTools Protocol
+func (b *Browser) isChromium() bool {
+	return b.browserClass() == BrowserClassChromium
+}
+
+func (b *Browser) browserType(pw *playwright.Playwright) playwright.BrowserType {
+	switch b.browserClass() {
+	case BrowserClassFirefox:
+		return pw.Firefox
+	case BrowserClassWebkit:
+		return pw.WebKit
+	default:
+		return pw.Chromium
+	}
+}
 
 // Sets up and returns a Playwright Browser instance with anti-detection measures.
-func (b *Browser) setupBuiltinBrowser(pw *playwright.Playwright) playwright.Browser {
-	if b.Config["browser_binary_path"] != nil {
-		panic("browser_binary_path should be None if trying to use the builtin browsers")
+func (b *Browser) setupBuiltinBrowser(pw *playwright.Playwright) (playwright.Browser, error) {
+	if b.Config.BrowserBinaryPath != "" {
+		return nil, &BrowserError{Message: "browser_binary_path should be None if trying to use the builtin browsers"}
+	}
+
+	browser, err := b.browserType(pw).Launch(
+		playwright.BrowserTypeLaunchOptions{
+			Headless:      playwright.Bool(b.Config.Headless),
+			Args:          b.builtinBrowserArgs(),
+			Proxy:         b.Config.Proxy.toPlaywright(),
+			HandleSIGTERM: playwright.Bool(false),
+			HandleSIGINT:  playwright.Bool(false),
+		},
+	)
+	if err != nil {
+		return nil, NewBrowserLaunchError(fmt.Errorf("%s: %w", b.browserClass(), err))
+	}
+	return browser, nil
+}
+
+// Launch args for the configured engine, the chrome args only apply to chromium
+func (b *Browser) builtinBrowserArgs() []string {
+	extraArgs := b.Config.ExtraBrowserArgs
+	switch b.browserClass() {
+	case BrowserClassFirefox:
+		return append([]string{"-no-remote"}, extraArgs...)
+	case BrowserClassWebkit:
+		return append([]string{"--no-startup-window"}, extraArgs...)
 	}
+
 	var screenSize map[string]int
 	var offsetX, offsetY int
-	if headless, ok := b.Config["headless"].(bool); ok && headless {
+	if b.Config.Headless {
 		screenSize = map[string]int{"width": 1920, "height": 1080}
 		offsetX, offsetY = 0, 0
 	} else {
@@ -126,13 +402,13 @@ func (b *Browser) setupBuiltinBrowser(pw *playwright.Playwright) playwright.Brow
 	if IN_DOCKER {
 		chromeArgs = append(chromeArgs, CHROME_DOCKER_ARGS...)
 	}
-	if b.Config["headless"] != nil && b.Config["headless"].(bool) {
+	if b.Config.Headless {
 		chromeArgs = append(chromeArgs, CHROME_HEADLESS_ARGS...)
 	}
-	if b.Config["disable_security"] != nil && b.Config["disable_security"].(bool) {
+	if b.Config.DisableSecurity {
 		chromeArgs = append(chromeArgs, CHROME_DISABLE_SECURITY_ARGS...)
 	}
-	if b.Config["deterministic_rendering"] != nil && b.Config["deterministic_rendering"].(bool) {
+	if b.Config.DeterministicRendering {
 		chromeArgs = append(chromeArgs, CHROME_DETERMINISTIC_RENDERING_ARGS...)
 	}
 
@@ -143,9 +419,7 @@ func (b *Browser) setupBuiltinBrowser(pw *playwright.Playwright) playwright.Brow
 	)
 
 	// additional user specified args
-	if extraArgs, ok := b.Config["extra_browser_args"].([]string); ok {
-		chromeArgs = append(chromeArgs, extraArgs...)
-	}
+	chromeArgs = append(chromeArgs, extraArgs...)
 
 	// check if port 9222 is already taken, if so remove the remote-debugging-port arg to prevent conflicts
 	ln, err := net.Listen("tcp", "127.0.0.1:9222")
@@ -159,49 +433,5 @@ func (b *Browser) setupBuiltinBrowser(pw *playwright.Playwright) playwright.Brow
 	} else {
 		ln.Close()
 	}
-
-	browserType := pw.Chromium
-	// TODO(LOW): support firefox and webkit
-	// switch self.Config["browser_class"] {
-	// case "chromium":
-	// 	browserType = playwright.Chromium
-	// case "firefox":
-	// 	browserType = playwright.Firefox
-	// case "webkit":
-	// 	browserType = playwright.WebKit
-	// default:
-	// 	browserType = playwright.Chromium
-	// }
-	// args := map[string]interface{}{
-	// 	"chromium": chromeArgs,
-	// 	"firefox": []interface{}{
-	// 		"-no-remote",
-	// 		self.Config["extra_browser_args"],
-	// 	},
-	// 	"webkit": []interface{}{
-	// 		"--no-startup-window",
-	// 		self.Config["extra_browser_args"],
-	// 	},
-	// }
-
-	browser, err := browserType.Launch(
-		playwright.BrowserTypeLaunchOptions{
-			Headless: playwright.Bool(b.Config["headless"].(bool)),
-			Args:     chromeArgs,
-			Proxy:    nil,
-			// TODO(LOW): implement proxy
-			// &playwright.Proxy{
-			// 	Server:   b.Config["proxy"].(map[string]interface{})["server"].(string),
-			// 	Bypass:   playwright.String(b.Config["proxy"].(map[string]interface{})["bypass"].(string)),
-			// 	Username: playwright.String(b.Config["proxy"].(map[string]interface{})["username"].(string)),
-			// 	Password: playwright.String(b.Config["proxy"].(map[string]interface{})["password"].(string)),
-			// },
-			HandleSIGTERM: playwright.Bool(false),
-			HandleSIGINT:  playwright.Bool(false),
-		},
-	)
-	if err != nil {
-		panic(err)
-	}
-	return browser
+	return chromeArgs
 }
@@ -0,0 +1,118 @@
+package browser
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/playwright-community/playwright-go"
+)
+
+// Storage state (cookies and localStorage) persistence, configured with:
+//
+//	storage_state_path          file holding the playwright storage state
+//	cookies_file                file holding only the cookies, used when storage_state_path is not set
+//	save_storage_state_on_step  also save the storage state every time the browser state is read
+//
+// The state is loaded when the context is created and saved when it is closed.
+func (bc *BrowserContext) storageStateFile() (path string, cookiesOnly bool) {
+	if bc.Config.StorageStatePath != "" {
+		return bc.Config.StorageStatePath, false
+	}
+	return bc.Config.CookiesFile, true
+}
+
+// Load the saved storage state, returns nil if there is none
+func (bc *BrowserContext) loadStorageState() (*playwright.StorageState, error) {
+	path, _ := bc.storageStateFile()
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// a cookies file is a plain list of cookies
+	var cookies []playwright.Cookie
+	if err := json.Unmarshal(data, &cookies); err == nil {
+		return &playwright.StorageState{Cookies: cookies}, nil
+	}
+	var state playwright.StorageState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Apply the saved storage state to a context which already exists, e.g. one attached to over CDP
+func (bc *BrowserContext) applyStorageState(context playwright.BrowserContext, state *playwright.StorageState) error {
+	if state == nil || len(state.Cookies) == 0 {
+		return nil
+	}
+	if len(state.Origins) > 0 {
+		log.Debug("🍪  localStorage can not be restored into an existing context, only cookies are loaded")
+	}
+	return context.AddCookies(state.ToOptionalStorageState().Cookies)
+}
+
+// Save the storage state of the context to storage_state_path or cookies_file
+func (bc *BrowserContext) SaveStorageState() error {
+	path, cookiesOnly := bc.storageStateFile()
+	if path == "" || bc.Session == nil || bc.Session.Context == nil {
+		return nil
+	}
+	state, err := bc.Session.Context.StorageState()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if cookiesOnly {
+		data, err = json.MarshalIndent(state.Cookies, "", "  ")
+	} else {
+		data, err = json.MarshalIndent(state, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	log.Debugf("🍪  Saved %d cookies to %s", len(state.Cookies), path)
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it over path,
+// so readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// the storage state holds session cookies
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,7 +1,11 @@
 package browser
 
 import (
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/kbinani/screenshot"
 )
@@ -31,3 +35,29 @@ func getWindowAdjustments() (int, int) {
 		return 0, 0 // Linux or others
 	}
 }
+
+// isNewTabPage reports whether url is a blank or new tab page which is always allowed
+func isNewTabPage(url string) bool {
+	return url == "" ||
+		strings.HasPrefix(url, "about:") ||
+		url == "chrome://new-tab-page/" ||
+		url == "chrome://new-tab-page" ||
+		url == "chrome://newtab/"
+}
+
+// getUniqueFilename returns filename, or "name (n).ext" with the first n not already taken in dir
+func getUniqueFilename(dir string, filename string) string {
+	filename = filepath.Base(filename)
+	if filename == "." || filename == string(filepath.Separator) {
+		filename = "download"
+	}
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	candidate := filename
+	for counter := 1; ; counter++ {
+		if _, err := os.Stat(filepath.Join(dir, candidate)); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = base + " (" + strconv.Itoa(counter) + ")" + ext
+	}
+}
@@ -56,6 +56,7 @@ type BrowserStateHistory struct {
 	Title             string                   `json:"title"`
 	Tabs              []*TabInfo               `json:"tabs"`
 	InteractedElement []*dom.DOMHistoryElement `json:"interacted_element"`
+	Screenshot        *string                  `json:"screenshot,omitempty"`
 }
 
 // BrowserError is the base error type for all browser errors.
@@ -79,3 +80,60 @@ func NewURLNotAllowedError(url string) error {
 		},
 	}
 }
+
+// BrowserLaunchError is returned when playwright or the browser could not be started or connected to.
+type BrowserLaunchError struct {
+	BrowserError
+	Err error
+}
+
+func (e *BrowserLaunchError) Unwrap() error {
+	return e.Err
+}
+
+func NewBrowserLaunchError(err error) error {
+	return &BrowserLaunchError{
+		BrowserError: BrowserError{
+			Message: "Failed to launch browser: " + err.Error(),
+		},
+		Err: err,
+	}
+}
+
+// SessionClosedError is returned when the browser or the context of a session has been closed.
+type SessionClosedError struct {
+	BrowserError
+	Err error
+}
+
+func (e *SessionClosedError) Unwrap() error {
+	return e.Err
+}
+
+func NewSessionClosedError(err error) error {
+	return &SessionClosedError{
+		BrowserError: BrowserError{
+			Message: "Browser session closed: " + err.Error(),
+		},
+		Err: err,
+	}
+}
+
+// JSEvaluationError is returned when a script evaluated in the page failed or returned an unexpected result.
+type JSEvaluationError struct {
+	BrowserError
+	Err error
+}
+
+func (e *JSEvaluationError) Unwrap() error {
+	return e.Err
+}
+
+func NewJSEvaluationError(err error) error {
+	return &JSEvaluationError{
+		BrowserError: BrowserError{
+			Message: "JavaScript evaluation failed: " + err.Error(),
+		},
+		Err: err,
+	}
+}